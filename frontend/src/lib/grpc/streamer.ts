@@ -0,0 +1,59 @@
+import { Code, ConnectError } from "@connectrpc/connect";
+
+export interface StreamerHandler {
+  stream(signal: AbortSignal): Promise<void>;
+  onError(err: ConnectError): void;
+}
+
+export class Streamer {
+
+  private abortController: AbortController | null = null;
+  private retryCancel: number | null = null;
+
+  constructor(
+    private handler: StreamerHandler,
+    private retryInterval: number = 5000,
+  ) {
+
+  }
+
+  public abort() {
+    if (this.abortController) {
+      this.abortController.abort();
+    }
+    if (this.retryCancel) {
+      clearTimeout(this.retryCancel);
+      this.retryCancel = null;
+    }
+  }
+
+  private afterStream() {
+    this.abortController = null;
+    this.retryCancel = setTimeout(() => {
+      this.start();
+    }, this.retryInterval);
+  }
+
+  public async start() {
+    this.abort();
+
+    const abortController = new AbortController();
+    this.abortController = abortController;
+
+    await this.handler.stream(abortController.signal)
+      .then(() => {
+        if (!abortController.signal.aborted) {
+          this.afterStream();
+        }
+      })
+      .catch((err) => {
+        const connErr = ConnectError.from(err)
+        if (connErr.code === Code.Canceled) {
+          return;
+        }
+
+        this.handler.onError(connErr);
+        this.afterStream();
+      })
+  }
+}
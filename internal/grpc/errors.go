@@ -0,0 +1,152 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+
+	"connectrpc.com/connect"
+	"github.com/rneacsu/spyglass/internal/grpc/proto"
+	"github.com/rneacsu/spyglass/internal/kubernetes"
+	"google.golang.org/protobuf/types/known/structpb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newKubeError translates an error returned while talking to a cluster into a connect error with a
+// matching code, attaching the Kubernetes status as details when the API server returned one, and the live
+// object on update conflicts
+func newKubeError(err error) *connect.Error {
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		connectErr := connect.NewError(statusReasonToCode(status), err)
+
+		if detail, detailErr := newStatusDetail(status); detailErr == nil {
+			connectErr.AddDetail(detail)
+		}
+
+		var conflictErr *kubernetes.ConflictError
+		if errors.As(err, &conflictErr) {
+			if detail, detailErr := newConflictDetail(conflictErr); detailErr == nil {
+				connectErr.AddDetail(detail)
+			}
+		}
+
+		return connectErr
+	}
+
+	return connect.NewError(errorToCode(err), err)
+}
+
+func statusReasonToCode(status v1.Status) connect.Code {
+	switch status.Reason {
+	case v1.StatusReasonNotFound:
+		return connect.CodeNotFound
+	case v1.StatusReasonAlreadyExists:
+		return connect.CodeAlreadyExists
+	case v1.StatusReasonForbidden:
+		return connect.CodePermissionDenied
+	case v1.StatusReasonUnauthorized:
+		return connect.CodeUnauthenticated
+	case v1.StatusReasonConflict:
+		return connect.CodeAborted
+	case v1.StatusReasonTimeout, v1.StatusReasonServerTimeout:
+		return connect.CodeDeadlineExceeded
+	case v1.StatusReasonTooManyRequests:
+		return connect.CodeResourceExhausted
+	case v1.StatusReasonGone, v1.StatusReasonExpired:
+		return connect.CodeFailedPrecondition
+	case v1.StatusReasonBadRequest, v1.StatusReasonInvalid, v1.StatusReasonRequestEntityTooLarge:
+		return connect.CodeInvalidArgument
+	case v1.StatusReasonMethodNotAllowed, v1.StatusReasonNotAcceptable, v1.StatusReasonUnsupportedMediaType:
+		return connect.CodeUnimplemented
+	case v1.StatusReasonServiceUnavailable:
+		return connect.CodeUnavailable
+	}
+
+	// Fall back on the HTTP status code for reasons that are unknown or missing
+	switch status.Code {
+	case 401:
+		return connect.CodeUnauthenticated
+	case 403:
+		return connect.CodePermissionDenied
+	case 404:
+		return connect.CodeNotFound
+	case 409:
+		return connect.CodeAborted
+	case 410:
+		return connect.CodeFailedPrecondition
+	case 429:
+		return connect.CodeResourceExhausted
+	case 502, 503:
+		return connect.CodeUnavailable
+	case 504:
+		return connect.CodeDeadlineExceeded
+	}
+
+	return connect.CodeInternal
+}
+
+func errorToCode(err error) connect.Code {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return connect.CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return connect.CodeDeadlineExceeded
+	case errors.Is(err, kubernetes.ErrTooManyWatchers):
+		return connect.CodeResourceExhausted
+	case errors.Is(err, kubernetes.ErrRolloutPaused):
+		return connect.CodeFailedPrecondition
+	}
+
+	// The cluster could not be reached at all
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return connect.CodeUnavailable
+	}
+
+	return connect.CodeInternal
+}
+
+func newStatusDetail(status v1.Status) (*connect.ErrorDetail, error) {
+	kubeStatus, err := convertKubeStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewErrorDetail(kubeStatus)
+}
+
+func convertKubeStatus(status v1.Status) (*proto.KubeStatus, error) {
+	kubeStatus := &proto.KubeStatus{
+		Code:    status.Code,
+		Reason:  string(status.Reason),
+		Message: status.Message,
+	}
+
+	if status.Details != nil {
+		detailsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status.Details)
+		if err != nil {
+			return nil, err
+		}
+		kubeStatus.Details, err = structpb.NewStruct(detailsMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kubeStatus, nil
+}
+
+func newConflictDetail(conflictErr *kubernetes.ConflictError) (*connect.ErrorDetail, error) {
+	live, err := convertResource(conflictErr.Live)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewErrorDetail(&proto.ResourceConflict{Live: live})
+}
@@ -2,17 +2,24 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"io"
+	"regexp"
+	"sync"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/rneacsu/spyglass/internal/grpc/proto"
 	"github.com/rneacsu/spyglass/internal/kubernetes"
 	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
 type kubeHandler struct {
@@ -40,48 +47,78 @@ func (kh *kubeHandler) GetDefaultContext(context.Context, *connect.Request[proto
 func (kh *kubeHandler) Discover(ctx context.Context, req *connect.Request[proto.DiscoverRequest]) (*connect.Response[proto.DiscoverReply], error) {
 	kubeContext := req.Msg.Context
 
-	resources, err := kh.ks.Discover(ctx, kubeContext)
+	discovery, err := kh.ks.Discover(ctx, kubeContext)
 
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, newKubeError(err)
 	}
 
 	response := &proto.DiscoverReply{
-		Apis: make(map[string]*proto.DiscoverApi, len(resources)),
+		Apis:     make(map[string]*proto.DiscoverApi, len(discovery.APIs)),
+		Groups:   make(map[string]*proto.DiscoverGroup, len(discovery.Groups)),
+		Failures: make([]*proto.DiscoverFailure, 0, len(discovery.Failed)),
 	}
 
-	for _, resource := range resources {
-		gvSplit := strings.Split(resource.GroupVersion, "/")
-		var group string
-		var version string
-		if len(gvSplit) == 2 {
-			group = gvSplit[0]
-			version = gvSplit[1]
-		} else {
-			group = ""
-			version = gvSplit[0]
+	for _, group := range discovery.Groups {
+		response.Groups[group.Name] = &proto.DiscoverGroup{
+			Name:             group.Name,
+			Versions:         group.Versions,
+			PreferredVersion: group.PreferredVersion,
 		}
+	}
 
+	for _, discoveredAPI := range discovery.APIs {
 		api := &proto.DiscoverApi{
-			Group:     group,
-			Version:   version,
-			Resources: make([]*proto.DiscoverResource, 0, len(resource.APIResources)),
+			Group:     discoveredAPI.Group,
+			Version:   discoveredAPI.Version,
+			Preferred: discoveredAPI.Preferred,
+			Resources: make([]*proto.DiscoverResource, 0, len(discoveredAPI.Resources)),
 		}
 
-		for _, res := range resource.APIResources {
+		for _, res := range discoveredAPI.Resources {
 			api.Resources = append(api.Resources, &proto.DiscoverResource{
-				Name:       res.Name,
-				Namespaced: res.Namespaced,
+				Name:         res.Name,
+				Namespaced:   res.Namespaced,
+				Kind:         res.Kind,
+				SingularName: res.SingularName,
+				Verbs:        res.Verbs,
+				ShortNames:   res.ShortNames,
+				Categories:   res.Categories,
+				Subresources: res.Subresources,
+				Preferred:    res.Preferred,
 			})
 		}
 
-		response.Apis[resource.GroupVersion] = api
+		groupVersion := schema.GroupVersion{Group: discoveredAPI.Group, Version: discoveredAPI.Version}
+		response.Apis[groupVersion.String()] = api
+	}
+
+	for _, failed := range discovery.Failed {
+		status, err := convertResultStatus(failed.Err)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+
+		response.Failures = append(response.Failures, &proto.DiscoverFailure{
+			Group:   failed.Group,
+			Version: failed.Version,
+			Error:   failed.Err.Error(),
+			Status:  status,
+		})
 	}
 
 	return connect.NewResponse(response), nil
 }
 
-func (kh *kubeHandler) ListResource(ctx context.Context, req *connect.Request[proto.ListResourceRequest]) (*connect.Response[proto.ListResourceReply], error) {
+func (kh *kubeHandler) InvalidateDiscovery(ctx context.Context, req *connect.Request[proto.InvalidateDiscoveryRequest]) (*connect.Response[proto.Empty], error) {
+	if err := kh.ks.InvalidateDiscovery(req.Msg.Context); err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(&proto.Empty{}), nil
+}
+
+func (kh *kubeHandler) GetResource(ctx context.Context, req *connect.Request[proto.GetResourceRequest]) (*connect.Response[proto.GetResourceReply], error) {
 	kubeContext := req.Msg.Context
 	gvr := schema.GroupVersionResource{
 		Group:    req.Msg.Gvr.Group,
@@ -94,38 +131,167 @@ func (kh *kubeHandler) ListResource(ctx context.Context, req *connect.Request[pr
 		namespace = *req.Msg.Namespace
 	}
 
-	objs, err := kh.ks.ListResource(ctx, kubeContext, gvr, namespace)
+	obj, err := kh.ks.GetResource(ctx, kubeContext, gvr, namespace, req.Msg.Name)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
 
+	resource, err := convertResource(obj)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
-	response := &proto.ListResourceReply{
-		Resources: make([]*proto.Resource, 0, len(objs)),
+	return connect.NewResponse(&proto.GetResourceReply{Resource: resource}), nil
+}
+
+func (kh *kubeHandler) WatchResourceObject(ctx context.Context, req *connect.Request[proto.GetResourceRequest], stream *connect.ServerStream[proto.WatchResourceReply]) error {
+	kubeContext := req.Msg.Context
+	gvr := schema.GroupVersionResource{
+		Group:    req.Msg.Gvr.Group,
+		Version:  req.Msg.Gvr.Version,
+		Resource: req.Msg.Gvr.Resource,
 	}
 
-	for _, obj := range objs {
-		raw, err := structpb.NewStruct(obj.Object)
+	namespace := ""
+	if req.Msg.Namespace != nil {
+		namespace = *req.Msg.Namespace
+	}
+
+	for {
+		obj, sub, err := kh.ks.WatchResourceObject(ctx, kubeContext, gvr, namespace, req.Msg.Name)
+		if err != nil {
+			return newKubeError(err)
+		}
+
+		list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*obj}}
+		list.SetResourceVersion(obj.GetResourceVersion())
+
+		err = streamResourceEvents(ctx, stream, list, sub.Events)
+		sub.Close()
+
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil || sub.WatcherStopped() {
+			return nil
+		}
+
+		// The watcher stopped watching or the stream fell behind, resubscribe and send a fresh snapshot
+	}
+}
+
+func (kh *kubeHandler) UpdateResource(ctx context.Context, req *connect.Request[proto.UpdateResourceRequest]) (*connect.Response[proto.UpdateResourceReply], error) {
+	gvr := schema.GroupVersionResource{
+		Group:    req.Msg.Gvr.Group,
+		Version:  req.Msg.Gvr.Version,
+		Resource: req.Msg.Gvr.Resource,
+	}
+
+	obj, err := kh.ks.UpdateResource(ctx, req.Msg.Context, gvr, []byte(req.Msg.Manifest), req.Msg.DryRun)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	resource, err := convertResource(obj)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&proto.UpdateResourceReply{Resource: resource}), nil
+}
+
+func (kh *kubeHandler) ApplyResource(ctx context.Context, req *connect.Request[proto.ApplyResourceRequest]) (*connect.Response[proto.ApplyResourceReply], error) {
+	results, err := kh.ks.ApplyManifest(ctx, req.Msg.Context, []byte(req.Msg.Manifest), kubernetes.ApplyOptions{
+		Namespace: req.Msg.Namespace,
+		Force:     req.Msg.Force,
+		DryRun:    req.Msg.DryRun,
+	})
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	reply := &proto.ApplyResourceReply{
+		Results: make([]*proto.ApplyResult, 0, len(results)),
+	}
+	for _, result := range results {
+		r, err := convertApplyResult(result)
 		if err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
-		response.Resources = append(response.Resources, &proto.Resource{
-			Name: obj.GetName(),
-			Gvk: &proto.GVK{
-				Group:   obj.GroupVersionKind().Group,
-				Version: obj.GroupVersionKind().Version,
-				Kind:    obj.GroupVersionKind().Kind,
-			},
-			Namespace: obj.GetNamespace(),
-			Raw:       raw,
-		})
+		reply.Results = append(reply.Results, r)
 	}
 
-	return connect.NewResponse(response), nil
+	return connect.NewResponse(reply), nil
 }
 
-func (kh *kubeHandler) ListResourceTabular(ctx context.Context, req *connect.Request[proto.ListResourceRequest]) (*connect.Response[proto.ListResourceTabularReply], error) {
-	kubeContext := req.Msg.Context
+func (kh *kubeHandler) DeleteResource(ctx context.Context, req *connect.Request[proto.DeleteResourceRequest]) (*connect.Response[proto.DeleteResourceReply], error) {
+	gvr := schema.GroupVersionResource{
+		Group:    req.Msg.Gvr.Group,
+		Version:  req.Msg.Gvr.Version,
+		Resource: req.Msg.Gvr.Resource,
+	}
+
+	opts := kubernetes.DeleteOptions{
+		GracePeriodSeconds: req.Msg.GracePeriodSeconds,
+		DryRun:             req.Msg.DryRun,
+	}
+	switch req.Msg.Propagation {
+	case proto.DeletionPropagation_DELETION_PROPAGATION_FOREGROUND:
+		opts.Propagation = v1.DeletePropagationForeground
+	case proto.DeletionPropagation_DELETION_PROPAGATION_BACKGROUND:
+		opts.Propagation = v1.DeletePropagationBackground
+	case proto.DeletionPropagation_DELETION_PROPAGATION_ORPHAN:
+		opts.Propagation = v1.DeletePropagationOrphan
+	}
+
+	var results []kubernetes.DeleteResult
+	if req.Msg.Name != "" {
+		namespace := ""
+		if req.Msg.Namespace != nil {
+			namespace = *req.Msg.Namespace
+		}
+
+		result, err := kh.ks.DeleteResource(ctx, req.Msg.Context, gvr, namespace, req.Msg.Name, opts)
+		if err != nil {
+			return nil, newKubeError(err)
+		}
+		results = []kubernetes.DeleteResult{result}
+	} else {
+		query := kubernetes.ResourceQuery{
+			GVR:           gvr,
+			Namespaces:    parseNamespaces(req.Msg.Namespace, req.Msg.Namespaces),
+			LabelSelector: req.Msg.LabelSelector,
+			FieldSelector: req.Msg.FieldSelector,
+		}
+		if err := query.NormalizeSelectors(); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		var err error
+		results, err = kh.ks.DeleteResources(ctx, req.Msg.Context, query, opts)
+		if err != nil {
+			return nil, newKubeError(err)
+		}
+	}
+
+	reply := &proto.DeleteResourceReply{
+		Results: make([]*proto.DeleteResult, 0, len(results)),
+	}
+	for _, result := range results {
+		r, err := convertDeleteResult(result)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		reply.Results = append(reply.Results, r)
+	}
+
+	return connect.NewResponse(reply), nil
+}
+
+func (kh *kubeHandler) ScaleResource(ctx context.Context, req *connect.Request[proto.ScaleResourceRequest]) (*connect.Response[proto.ScaleResourceReply], error) {
 	gvr := schema.GroupVersionResource{
 		Group:    req.Msg.Gvr.Group,
 		Version:  req.Msg.Gvr.Version,
@@ -137,55 +303,1046 @@ func (kh *kubeHandler) ListResourceTabular(ctx context.Context, req *connect.Req
 		namespace = *req.Msg.Namespace
 	}
 
-	table, err := kh.ks.ListResourceTabular(ctx, kubeContext, gvr, namespace)
+	if req.Msg.Replicas < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid number of replicas: %d", req.Msg.Replicas))
+	}
+
+	scale, err := kh.ks.ScaleResource(ctx, req.Msg.Context, gvr, namespace, req.Msg.Name, req.Msg.Replicas)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(&proto.ScaleResourceReply{
+		Replicas:        scale.Replicas,
+		CurrentReplicas: scale.CurrentReplicas,
+		Selector:        scale.Selector,
+	}), nil
+}
+
+func (kh *kubeHandler) RestartRollout(ctx context.Context, req *connect.Request[proto.RestartRolloutRequest]) (*connect.Response[proto.RestartRolloutReply], error) {
+	gvr := schema.GroupVersionResource{
+		Group:    req.Msg.Gvr.Group,
+		Version:  req.Msg.Gvr.Version,
+		Resource: req.Msg.Gvr.Resource,
+	}
+
+	namespace := ""
+	if req.Msg.Namespace != nil {
+		namespace = *req.Msg.Namespace
+	}
+
+	obj, err := kh.ks.RestartRollout(ctx, req.Msg.Context, gvr, namespace, req.Msg.Name)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	resource, err := convertResource(obj)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&proto.RestartRolloutReply{Resource: resource}), nil
+}
+
+func (kh *kubeHandler) GetRolloutHistory(ctx context.Context, req *connect.Request[proto.RolloutRequest]) (*connect.Response[proto.RolloutHistoryReply], error) {
+	gvr, namespace := parseRolloutRequest(req.Msg)
+
+	revisions, err := kh.ks.RolloutHistory(ctx, req.Msg.Context, gvr, namespace, req.Msg.Name)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	reply := &proto.RolloutHistoryReply{
+		Revisions: make([]*proto.RolloutRevision, 0, len(revisions)),
+	}
+	for _, revision := range revisions {
+		template, err := structpb.NewStruct(revision.Template)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		reply.Revisions = append(reply.Revisions, &proto.RolloutRevision{
+			Revision:    revision.Revision,
+			Name:        revision.Name,
+			ChangeCause: revision.ChangeCause,
+			Created:     timestamppb.New(revision.Created),
+			Current:     revision.Current,
+			Template:    template,
+		})
+	}
+
+	return connect.NewResponse(reply), nil
+}
+
+func (kh *kubeHandler) RollbackRollout(ctx context.Context, req *connect.Request[proto.RollbackRolloutRequest]) (*connect.Response[proto.RollbackRolloutReply], error) {
+	if req.Msg.Rollout == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("rollout is required"))
+	}
+	gvr, namespace := parseRolloutRequest(req.Msg.Rollout)
+
+	obj, err := kh.ks.RollbackRollout(ctx, req.Msg.Rollout.Context, gvr, namespace, req.Msg.Rollout.Name, req.Msg.Revision)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
 
+	resource, err := convertResource(obj)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&proto.RollbackRolloutReply{Resource: resource}), nil
+}
+
+func (kh *kubeHandler) DiffRolloutRevisions(ctx context.Context, req *connect.Request[proto.DiffRolloutRevisionsRequest]) (*connect.Response[proto.DiffRolloutRevisionsReply], error) {
+	if req.Msg.Rollout == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("rollout is required"))
+	}
+	gvr, namespace := parseRolloutRequest(req.Msg.Rollout)
+
+	changes, err := kh.ks.DiffRolloutRevisions(ctx, req.Msg.Rollout.Context, gvr, namespace, req.Msg.Rollout.Name, req.Msg.FromRevision, req.Msg.ToRevision)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	reply := &proto.DiffRolloutRevisionsReply{
+		Changes: make([]*proto.TemplateChange, 0, len(changes)),
+	}
+	for _, change := range changes {
+		c, err := convertTemplateChange(change)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		reply.Changes = append(reply.Changes, c)
+	}
+
+	return connect.NewResponse(reply), nil
+}
+
+func (kh *kubeHandler) ListResource(ctx context.Context, req *connect.Request[proto.ListResourceRequest]) (*connect.Response[proto.ListResourceReply], error) {
+	kubeContext := req.Msg.Context
+	query, err := parseListResourceRequest(req.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*unstructured.Unstructured
+	var continueToken string
+
+	if req.Msg.PageSize > 0 {
+		objs, continueToken, err = kh.ks.ListResourcePage(ctx, kubeContext, query, req.Msg.PageSize, req.Msg.Continue)
+	} else {
+		objs, err = kh.ks.ListResource(ctx, kubeContext, query)
+	}
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	response := &proto.ListResourceReply{
+		Resources: make([]*proto.Resource, 0, len(objs)),
+		Continue:  continueToken,
+	}
+
+	for _, obj := range objs {
+		resource, err := convertResource(obj)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		response.Resources = append(response.Resources, resource)
+	}
+
+	return connect.NewResponse(response), nil
+}
+
+func (kh *kubeHandler) WatchResource(ctx context.Context, req *connect.Request[proto.ListResourceRequest], stream *connect.ServerStream[proto.WatchResourceReply]) error {
+	kubeContext := req.Msg.Context
+	query, err := parseListResourceRequest(req.Msg)
+	if err != nil {
+		return err
+	}
+
+	for {
+		list, sub, err := kh.ks.WatchResource(ctx, kubeContext, query)
+		if err != nil {
+			return newKubeError(err)
+		}
+
+		err = streamResourceEvents(ctx, stream, list, sub.Events)
+		sub.Close()
+
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil || sub.WatcherStopped() {
+			return nil
+		}
+
+		// The watcher relisted or the stream fell behind, resubscribe and send a fresh snapshot
+	}
+}
+
+func streamResourceEvents(ctx context.Context, stream *connect.ServerStream[proto.WatchResourceReply], list *unstructured.UnstructuredList, events <-chan kubernetes.ResourceEvent) error {
+	snapshot := &proto.WatchResourceReply{
+		Type:            proto.WatchEventType_WATCH_EVENT_TYPE_SNAPSHOT,
+		Resources:       make([]*proto.Resource, 0, len(list.Items)),
+		ResourceVersion: list.GetResourceVersion(),
+	}
+	for i := range list.Items {
+		resource, err := convertResource(&list.Items[i])
+		if err != nil {
+			return connect.NewError(connect.CodeInternal, err)
+		}
+		snapshot.Resources = append(snapshot.Resources, resource)
+	}
+
+	if err := stream.Send(snapshot); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			resource, err := convertResource(event.Object)
+			if err != nil {
+				return connect.NewError(connect.CodeInternal, err)
+			}
+
+			err = stream.Send(&proto.WatchResourceReply{
+				Type:            convertWatchEventType(event.Type),
+				Resources:       []*proto.Resource{resource},
+				ResourceVersion: event.Object.GetResourceVersion(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (kh *kubeHandler) ListResourceTabular(ctx context.Context, req *connect.Request[proto.ListResourceRequest]) (*connect.Response[proto.ListResourceTabularReply], error) {
+	kubeContext := req.Msg.Context
+	query, err := parseListResourceRequest(req.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var table *v1.Table
+	var continueToken string
+
+	if req.Msg.PageSize > 0 {
+		table, continueToken, err = kh.ks.ListResourceTabularPage(ctx, kubeContext, query, req.Msg.PageSize, req.Msg.Continue)
+	} else {
+		table, err = kh.ks.ListResourceTabular(ctx, kubeContext, query)
+	}
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	rows, err := convertTableRows(table.Rows)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
 	response := &proto.ListResourceTabularReply{
-		Columns: make([]*proto.ListResourceTabularReply_TabularColumn, 0, len(table.ColumnDefinitions)),
-		Rows:    make([]*proto.ListResourceTabularReply_TabularRow, 0, len(table.Rows)),
+		Columns:  convertTableColumns(table.ColumnDefinitions),
+		Rows:     rows,
+		Continue: continueToken,
+	}
+
+	return connect.NewResponse(response), nil
+}
+
+func (kh *kubeHandler) WatchResourceTabular(ctx context.Context, req *connect.Request[proto.ListResourceRequest], stream *connect.ServerStream[proto.WatchResourceTabularReply]) error {
+	kubeContext := req.Msg.Context
+	query, err := parseListResourceRequest(req.Msg)
+	if err != nil {
+		return err
+	}
+
+	for {
+		table, sub, err := kh.ks.WatchResourceTabular(ctx, kubeContext, query)
+		if err != nil {
+			return newKubeError(err)
+		}
+
+		err = streamTableEvents(ctx, stream, table, sub.Events)
+		sub.Close()
+
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil || sub.WatcherStopped() {
+			return nil
+		}
+
+		// The watcher relisted or the stream fell behind, resubscribe and send a fresh snapshot
+	}
+}
+
+func streamTableEvents(ctx context.Context, stream *connect.ServerStream[proto.WatchResourceTabularReply], table *v1.Table, events <-chan kubernetes.TableEvent) error {
+	rows, err := convertTableRows(table.Rows)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+
+	err = stream.Send(&proto.WatchResourceTabularReply{
+		Type:    proto.WatchEventType_WATCH_EVENT_TYPE_SNAPSHOT,
+		Columns: convertTableColumns(table.ColumnDefinitions),
+		Rows:    rows,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			row, err := convertTableRow(event.Row)
+			if err != nil {
+				return connect.NewError(connect.CodeInternal, err)
+			}
+
+			err = stream.Send(&proto.WatchResourceTabularReply{
+				Type: convertWatchEventType(event.Type),
+				Rows: []*proto.ListResourceTabularReply_TabularRow{row},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func parseListResourceRequest(msg *proto.ListResourceRequest) (kubernetes.ResourceQuery, error) {
+	query := kubernetes.ResourceQuery{
+		GVR: schema.GroupVersionResource{
+			Group:    msg.Gvr.Group,
+			Version:  msg.Gvr.Version,
+			Resource: msg.Gvr.Resource,
+		},
+		Namespaces:    parseNamespaces(msg.Namespace, msg.Namespaces),
+		LabelSelector: msg.LabelSelector,
+		FieldSelector: msg.FieldSelector,
+	}
+
+	if err := query.NormalizeSelectors(); err != nil {
+		return query, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return query, nil
+}
+
+// parseNamespaces returns the explicit set of namespaces if any, otherwise the single optional namespace
+func parseNamespaces(namespace *string, namespaces []string) []string {
+	if len(namespaces) > 0 {
+		return namespaces
+	}
+	if namespace != nil {
+		return []string{*namespace}
+	}
+	return []string{""}
+}
+
+func convertWatchEventType(eventType watch.EventType) proto.WatchEventType {
+	switch eventType {
+	case watch.Added:
+		return proto.WatchEventType_WATCH_EVENT_TYPE_ADDED
+	case watch.Modified:
+		return proto.WatchEventType_WATCH_EVENT_TYPE_MODIFIED
+	case watch.Deleted:
+		return proto.WatchEventType_WATCH_EVENT_TYPE_DELETED
+	default:
+		return proto.WatchEventType_WATCH_EVENT_TYPE_UNSPECIFIED
+	}
+}
+
+func convertResource(obj *unstructured.Unstructured) (*proto.Resource, error) {
+	raw, err := structpb.NewStruct(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.Resource{
+		Name: obj.GetName(),
+		Gvk: &proto.GVK{
+			Group:   obj.GroupVersionKind().Group,
+			Version: obj.GroupVersionKind().Version,
+			Kind:    obj.GroupVersionKind().Kind,
+		},
+		Namespace:       obj.GetNamespace(),
+		Raw:             raw,
+		Created:         timestamppb.New(obj.GetCreationTimestamp().Time),
+		Uid:             string(obj.GetUID()),
+		ResourceVersion: obj.GetResourceVersion(),
+	}, nil
+}
+
+func convertApplyResult(result kubernetes.ApplyResult) (*proto.ApplyResult, error) {
+	r := &proto.ApplyResult{
+		Gvk: &proto.GVK{
+			Group:   result.GVK.Group,
+			Version: result.GVK.Version,
+			Kind:    result.GVK.Kind,
+		},
+		Namespace: result.Namespace,
+		Name:      result.Name,
 	}
 
-	for _, col := range table.ColumnDefinitions {
-		response.Columns = append(response.Columns, &proto.ListResourceTabularReply_TabularColumn{
+	switch result.Action {
+	case kubernetes.ApplyActionCreated:
+		r.Action = proto.ApplyAction_APPLY_ACTION_CREATED
+	case kubernetes.ApplyActionConfigured:
+		r.Action = proto.ApplyAction_APPLY_ACTION_CONFIGURED
+	case kubernetes.ApplyActionUnchanged:
+		r.Action = proto.ApplyAction_APPLY_ACTION_UNCHANGED
+	case kubernetes.ApplyActionError:
+		r.Action = proto.ApplyAction_APPLY_ACTION_ERROR
+	}
+
+	if result.Err != nil {
+		var err error
+		r.Error = result.Err.Error()
+		r.Status, err = convertResultStatus(result.Err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Object != nil {
+		resource, err := convertResource(result.Object)
+		if err != nil {
+			return nil, err
+		}
+		r.Resource = resource
+	}
+
+	return r, nil
+}
+
+func convertDeleteResult(result kubernetes.DeleteResult) (*proto.DeleteResult, error) {
+	r := &proto.DeleteResult{
+		Namespace: result.Namespace,
+		Name:      result.Name,
+		Uid:       string(result.UID),
+		Removed:   result.Removed,
+	}
+
+	if result.Err != nil {
+		var err error
+		r.Error = result.Err.Error()
+		r.Status, err = convertResultStatus(result.Err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Object != nil {
+		resource, err := convertResource(result.Object)
+		if err != nil {
+			return nil, err
+		}
+		r.Resource = resource
+	}
+
+	return r, nil
+}
+
+// convertResultStatus returns the Kubernetes status of the error of a single object in a batch, if any
+func convertResultStatus(err error) (*proto.KubeStatus, error) {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		return nil, nil
+	}
+	return convertKubeStatus(apiStatus.Status())
+}
+
+func parseRolloutRequest(msg *proto.RolloutRequest) (schema.GroupVersionResource, string) {
+	gvr := schema.GroupVersionResource{
+		Group:    msg.Gvr.Group,
+		Version:  msg.Gvr.Version,
+		Resource: msg.Gvr.Resource,
+	}
+
+	namespace := ""
+	if msg.Namespace != nil {
+		namespace = *msg.Namespace
+	}
+
+	return gvr, namespace
+}
+
+func convertTemplateChange(change kubernetes.TemplateChange) (*proto.TemplateChange, error) {
+	c := &proto.TemplateChange{
+		Path: change.Path,
+	}
+
+	switch change.Type {
+	case kubernetes.TemplateChangeAdded:
+		c.Type = proto.TemplateChangeType_TEMPLATE_CHANGE_TYPE_ADDED
+	case kubernetes.TemplateChangeRemoved:
+		c.Type = proto.TemplateChangeType_TEMPLATE_CHANGE_TYPE_REMOVED
+	case kubernetes.TemplateChangeModified:
+		c.Type = proto.TemplateChangeType_TEMPLATE_CHANGE_TYPE_MODIFIED
+	}
+
+	var err error
+	if change.OldValue != nil {
+		if c.OldValue, err = structpb.NewValue(change.OldValue); err != nil {
+			return nil, err
+		}
+	}
+	if change.NewValue != nil {
+		if c.NewValue, err = structpb.NewValue(change.NewValue); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func convertTableColumns(columnDefinitions []v1.TableColumnDefinition) []*proto.ListResourceTabularReply_TabularColumn {
+	columns := make([]*proto.ListResourceTabularReply_TabularColumn, 0, len(columnDefinitions))
+	for _, col := range columnDefinitions {
+		columns = append(columns, &proto.ListResourceTabularReply_TabularColumn{
 			Name: col.Name,
 			Type: col.Type,
 		})
 	}
-	for _, row := range table.Rows {
-		r := &proto.ListResourceTabularReply_TabularRow{
-			Cells: make([]string, 0, len(row.Cells)),
+	return columns
+}
+
+func convertTableRows(tableRows []v1.TableRow) ([]*proto.ListResourceTabularReply_TabularRow, error) {
+	rows := make([]*proto.ListResourceTabularReply_TabularRow, 0, len(tableRows))
+	for _, row := range tableRows {
+		r, err := convertTableRow(row)
+		if err != nil {
+			return nil, err
 		}
-		for _, cell := range row.Cells {
-			r.Cells = append(r.Cells, fmt.Sprintf("%v", cell))
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+func convertTableRow(row v1.TableRow) (*proto.ListResourceTabularReply_TabularRow, error) {
+	r := &proto.ListResourceTabularReply_TabularRow{
+		Cells: make([]string, 0, len(row.Cells)),
+	}
+	for _, cell := range row.Cells {
+		r.Cells = append(r.Cells, fmt.Sprintf("%v", cell))
+	}
+	pom := row.Object.Object.(*v1.PartialObjectMetadata)
+
+	objMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pom)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := structpb.NewStruct(objMap)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Resource = &proto.Resource{
+		Name:      pom.Name,
+		Namespace: pom.Namespace,
+		Gvk: &proto.GVK{
+			Group:   pom.GroupVersionKind().Group,
+			Version: pom.GroupVersionKind().Version,
+			Kind:    pom.GroupVersionKind().Kind,
+		},
+		Raw:             raw,
+		Created:         timestamppb.New(pom.CreationTimestamp.Time),
+		Uid:             string(pom.UID),
+		ResourceVersion: pom.ResourceVersion,
+	}
+
+	return r, nil
+}
+
+func (kh *kubeHandler) ListCustomColumns(ctx context.Context, req *connect.Request[proto.Empty]) (*connect.Response[proto.CustomColumnsReply], error) {
+	return connect.NewResponse(convertCustomColumnSets(kh.ks.ListCustomColumns())), nil
+}
+
+func (kh *kubeHandler) SetCustomColumns(ctx context.Context, req *connect.Request[proto.SetCustomColumnsRequest]) (*connect.Response[proto.CustomColumnsReply], error) {
+	gvr := schema.GroupVersionResource{
+		Group:    req.Msg.Gvr.Group,
+		Version:  req.Msg.Gvr.Version,
+		Resource: req.Msg.Gvr.Resource,
+	}
+
+	columns := make([]kubernetes.CustomColumn, 0, len(req.Msg.Columns))
+	for _, column := range req.Msg.Columns {
+		columns = append(columns, kubernetes.CustomColumn{
+			Name:     column.Name,
+			JSONPath: column.GetJsonPath(),
+			CEL:      column.GetCel(),
+		})
+	}
+
+	if err := kh.ks.SetCustomColumns(gvr, columns); err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(convertCustomColumnSets(kh.ks.ListCustomColumns())), nil
+}
+
+func (kh *kubeHandler) ImportCustomColumns(ctx context.Context, req *connect.Request[proto.ImportCustomColumnsRequest]) (*connect.Response[proto.CustomColumnsReply], error) {
+	if err := kh.ks.ImportCustomColumns([]byte(req.Msg.Preset), req.Msg.Replace); err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(convertCustomColumnSets(kh.ks.ListCustomColumns())), nil
+}
+
+func (kh *kubeHandler) ExportCustomColumns(ctx context.Context, req *connect.Request[proto.Empty]) (*connect.Response[proto.ExportCustomColumnsReply], error) {
+	preset, err := kh.ks.ExportCustomColumns()
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&proto.ExportCustomColumnsReply{Preset: string(preset)}), nil
+}
+
+func convertCustomColumnSets(sets []kubernetes.CustomColumnSet) *proto.CustomColumnsReply {
+	reply := &proto.CustomColumnsReply{
+		Resources: make([]*proto.CustomColumnSet, 0, len(sets)),
+	}
+
+	for _, set := range sets {
+		columnSet := &proto.CustomColumnSet{
+			Gvr: &proto.GVR{
+				Group:    set.Group,
+				Version:  set.Version,
+				Resource: set.Resource,
+			},
+			Columns: make([]*proto.CustomColumn, 0, len(set.Columns)),
 		}
-		pom := row.Object.Object.(*v1.PartialObjectMetadata)
 
-		objMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pom)
-		if err != nil {
-			return nil, connect.NewError(connect.CodeInternal, err)
+		for _, column := range set.Columns {
+			c := &proto.CustomColumn{Name: column.Name}
+			if column.CEL != "" {
+				c.Expression = &proto.CustomColumn_Cel{Cel: column.CEL}
+			} else {
+				c.Expression = &proto.CustomColumn_JsonPath{JsonPath: column.JSONPath}
+			}
+			columnSet.Columns = append(columnSet.Columns, c)
 		}
-		raw, err := structpb.NewStruct(objMap)
+
+		reply.Resources = append(reply.Resources, columnSet)
+	}
+
+	return reply
+}
+
+func (kh *kubeHandler) StreamPodLogs(ctx context.Context, req *connect.Request[proto.StreamPodLogsRequest], stream *connect.ServerStream[proto.StreamPodLogsReply]) error {
+	opts := kubernetes.LogOptions{
+		Container:  req.Msg.Container,
+		Follow:     req.Msg.Follow,
+		TailLines:  req.Msg.TailLines,
+		Timestamps: req.Msg.Timestamps,
+		Previous:   req.Msg.Previous,
+	}
+	if req.Msg.SinceTime != nil {
+		sinceTime := req.Msg.SinceTime.AsTime()
+		opts.SinceTime = &sinceTime
+	}
+
+	err := kh.ks.StreamPodLogs(ctx, req.Msg.Context, req.Msg.Namespace, req.Msg.Pod, opts, func(line string) error {
+		return stream.Send(&proto.StreamPodLogsReply{Line: line})
+	})
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return newKubeError(err)
+	}
+
+	return nil
+}
+
+func (kh *kubeHandler) TailPodLogs(ctx context.Context, req *connect.Request[proto.TailPodLogsRequest], stream *connect.ServerStream[proto.TailPodLogsReply]) error {
+	query := kubernetes.ResourceQuery{
+		Namespaces:    parseNamespaces(req.Msg.Namespace, req.Msg.Namespaces),
+		LabelSelector: req.Msg.LabelSelector,
+	}
+	if err := query.NormalizeSelectors(); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	opts := kubernetes.TailOptions{
+		TailLines: req.Msg.TailLines,
+	}
+	if req.Msg.Container != "" {
+		containerRegexp, err := regexp.Compile(req.Msg.Container)
 		if err != nil {
-			return nil, connect.NewError(connect.CodeInternal, err)
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid container pattern: %w", err))
 		}
+		opts.Container = containerRegexp
+	}
+	if req.Msg.SinceTime != nil {
+		sinceTime := req.Msg.SinceTime.AsTime()
+		opts.SinceTime = &sinceTime
+	}
 
-		r.Resource = &proto.Resource{
-			Name:      pom.Name,
-			Namespace: pom.Namespace,
-			Gvk: &proto.GVK{
-				Group:   pom.GroupVersionKind().Group,
-				Version: pom.GroupVersionKind().Version,
-				Kind:    pom.GroupVersionKind().Kind,
-			},
-			Raw:     raw,
-			Created: timestamppb.New(pom.CreationTimestamp.Time),
-			Uid:     string(pom.UID),
+	err := kh.ks.TailPodLogs(ctx, req.Msg.Context, query, opts, func(line kubernetes.LogLine) error {
+		return stream.Send(&proto.TailPodLogsReply{
+			Namespace: line.Namespace,
+			Pod:       line.Pod,
+			Container: line.Container,
+			Timestamp: timestamppb.New(line.Timestamp),
+			Line:      line.Line,
+		})
+	})
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
 		}
-		response.Rows = append(response.Rows, r)
+		return newKubeError(err)
 	}
 
-	return connect.NewResponse(response), nil
+	return nil
+}
+
+func (kh *kubeHandler) ExecPod(ctx context.Context, stream *connect.BidiStream[proto.ExecPodRequest, proto.ExecPodReply]) error {
+	msg, err := stream.Receive()
+	if err != nil {
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	start := msg.GetStart()
+	if start == nil {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("exec session must begin with a start message"))
+	}
+	if len(start.Command) == 0 {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("command is required"))
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdin := newStdinQueue()
+	go stdin.feed(stdinWriter)
+
+	// Only the latest terminal size matters, older sizes not yet applied are dropped
+	resize := make(chan kubernetes.TerminalSize, 1)
+	if start.Size != nil {
+		resize <- convertTerminalSize(start.Size)
+	}
+
+	execCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The receive loop ends along with the handler, which gives up the request body
+	receiveErr := make(chan error, 1)
+	go func() {
+		defer close(resize)
+		for {
+			msg, err := stream.Receive()
+			if err != nil {
+				// The client closing its side of the stream closes the stdin of the command
+				if errors.Is(err, io.EOF) {
+					stdin.close(nil)
+				} else {
+					stdin.close(err)
+					receiveErr <- err
+					cancel()
+				}
+				return
+			}
+
+			switch m := msg.Message.(type) {
+			case *proto.ExecPodRequest_Stdin:
+				// Nothing reads the stdin of a command started without it, the input is dropped
+				if start.Stdin {
+					stdin.push(m.Stdin)
+				}
+			case *proto.ExecPodRequest_Resize:
+				select {
+				case <-resize:
+				default:
+				}
+				resize <- convertTerminalSize(m.Resize)
+			}
+		}
+	}()
+
+	var sendLock sync.Mutex
+	opts := kubernetes.ExecOptions{
+		Container: start.Container,
+		Command:   start.Command,
+		Stdin:     start.Stdin,
+		TTY:       start.Tty,
+	}
+	streams := kubernetes.ExecStreams{
+		Stdin:  stdinReader,
+		Stdout: &execOutputWriter{stream: stream, lock: &sendLock},
+		Stderr: &execOutputWriter{stream: stream, lock: &sendLock, stderr: true},
+		Resize: resize,
+	}
+
+	exitCode, err := kh.ks.ExecPod(execCtx, start.Context, start.Namespace, start.Pod, opts, streams)
+	// Input still queued is dropped, the command has ended
+	stdinReader.Close()
+	if err != nil {
+		select {
+		case err := <-receiveErr:
+			return err
+		default:
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		return newKubeError(err)
+	}
+
+	sendLock.Lock()
+	defer sendLock.Unlock()
+	return stream.Send(&proto.ExecPodReply{
+		Message: &proto.ExecPodReply_Exit{Exit: int32(exitCode)},
+	})
+}
+
+// stdinQueue buffers the input of an exec session, so receiving from the client never waits for the
+// command to read its input
+type stdinQueue struct {
+	lock   sync.Mutex
+	chunks [][]byte
+	closed bool
+	err    error
+	ready  chan struct{}
+}
+
+func newStdinQueue() *stdinQueue {
+	return &stdinQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *stdinQueue) push(p []byte) {
+	q.lock.Lock()
+	q.chunks = append(q.chunks, p)
+	q.lock.Unlock()
+	q.notify()
+}
+
+// close ends the input once the queued chunks are written, the reader then gets the given error or EOF
+func (q *stdinQueue) close(err error) {
+	q.lock.Lock()
+	q.closed = true
+	q.err = err
+	q.lock.Unlock()
+	q.notify()
+}
+
+func (q *stdinQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// feed writes the queued input to w until the queue is closed. Writes fail once the reader is closed,
+// the remaining input being dropped.
+func (q *stdinQueue) feed(w *io.PipeWriter) {
+	for {
+		q.lock.Lock()
+		chunks := q.chunks
+		q.chunks = nil
+		closed, err := q.closed, q.err
+		q.lock.Unlock()
+
+		for _, chunk := range chunks {
+			_, _ = w.Write(chunk)
+		}
+		if closed {
+			_ = w.CloseWithError(err)
+			return
+		}
+
+		<-q.ready
+	}
+}
+
+// execOutputWriter sends the output of an exec session to the client. Stdout and stderr are copied
+// concurrently, so sends are serialized through a shared lock.
+type execOutputWriter struct {
+	stream *connect.BidiStream[proto.ExecPodRequest, proto.ExecPodReply]
+	lock   *sync.Mutex
+	stderr bool
+}
+
+func (w *execOutputWriter) Write(p []byte) (int, error) {
+	reply := &proto.ExecPodReply{}
+	if w.stderr {
+		reply.Message = &proto.ExecPodReply_Stderr{Stderr: p}
+	} else {
+		reply.Message = &proto.ExecPodReply_Stdout{Stdout: p}
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.stream.Send(reply); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func convertTerminalSize(size *proto.TerminalSize) kubernetes.TerminalSize {
+	return kubernetes.TerminalSize{
+		Width:  uint16(size.Width),
+		Height: uint16(size.Height),
+	}
+}
+
+func (kh *kubeHandler) StartPortForward(ctx context.Context, req *connect.Request[proto.StartPortForwardRequest]) (*connect.Response[proto.PortForward], error) {
+	var kind kubernetes.PortForwardKind
+	switch req.Msg.Kind {
+	case proto.PortForwardKind_PORT_FORWARD_KIND_POD:
+		kind = kubernetes.PortForwardKindPod
+	case proto.PortForwardKind_PORT_FORWARD_KIND_SERVICE:
+		kind = kubernetes.PortForwardKindService
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported port forward kind: %s", req.Msg.Kind))
+	}
+	if req.Msg.RemotePort < 1 || req.Msg.RemotePort > 65535 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid remote port: %d", req.Msg.RemotePort))
+	}
+	if req.Msg.LocalPort > 65535 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid local port: %d", req.Msg.LocalPort))
+	}
+
+	status, err := kh.ks.StartPortForward(req.Msg.Context, kubernetes.PortForwardOptions{
+		Namespace:    req.Msg.Namespace,
+		Kind:         kind,
+		Name:         req.Msg.Name,
+		RemotePort:   req.Msg.RemotePort,
+		LocalAddress: req.Msg.LocalAddress,
+		LocalPort:    uint16(req.Msg.LocalPort),
+	})
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(convertPortForward(status)), nil
+}
+
+func (kh *kubeHandler) ListPortForwards(ctx context.Context, req *connect.Request[proto.ListPortForwardsRequest]) (*connect.Response[proto.ListPortForwardsReply], error) {
+	statuses := kh.ks.ListPortForwards(req.Msg.Context)
+
+	portForwards := make([]*proto.PortForward, 0, len(statuses))
+	for _, status := range statuses {
+		portForwards = append(portForwards, convertPortForward(status))
+	}
+
+	return connect.NewResponse(&proto.ListPortForwardsReply{PortForwards: portForwards}), nil
+}
+
+func (kh *kubeHandler) StopPortForward(ctx context.Context, req *connect.Request[proto.StopPortForwardRequest]) (*connect.Response[proto.Empty], error) {
+	if err := kh.ks.StopPortForward(req.Msg.Id); err != nil {
+		return nil, newKubeError(err)
+	}
+
+	return connect.NewResponse(&proto.Empty{}), nil
+}
+
+func convertPortForward(status kubernetes.PortForwardStatus) *proto.PortForward {
+	kind := proto.PortForwardKind_PORT_FORWARD_KIND_UNSPECIFIED
+	switch status.Kind {
+	case kubernetes.PortForwardKindPod:
+		kind = proto.PortForwardKind_PORT_FORWARD_KIND_POD
+	case kubernetes.PortForwardKindService:
+		kind = proto.PortForwardKind_PORT_FORWARD_KIND_SERVICE
+	}
+
+	return &proto.PortForward{
+		Id:           status.ID,
+		Context:      status.KubeContext,
+		Namespace:    status.Namespace,
+		Kind:         kind,
+		Name:         status.Name,
+		RemotePort:   status.RemotePort,
+		LocalAddress: status.LocalAddress,
+		LocalPort:    uint32(status.LocalPort),
+		Pod:          status.Pod,
+		PodPort:      status.PodPort,
+		Started:      timestamppb.New(status.Started),
+		Error:        status.Error,
+	}
+}
+
+func (kh *kubeHandler) CordonNode(ctx context.Context, req *connect.Request[proto.CordonNodeRequest]) (*connect.Response[proto.CordonNodeReply], error) {
+	obj, err := kh.ks.CordonNode(ctx, req.Msg.Context, req.Msg.Name, req.Msg.Unschedulable)
+
+	if err != nil {
+		return nil, newKubeError(err)
+	}
+
+	resource, err := convertResource(obj)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&proto.CordonNodeReply{Resource: resource}), nil
+}
+
+func (kh *kubeHandler) DrainNode(ctx context.Context, req *connect.Request[proto.DrainNodeRequest], stream *connect.ServerStream[proto.DrainNodeReply]) error {
+	opts := kubernetes.DrainOptions{
+		GracePeriodSeconds: req.Msg.GracePeriodSeconds,
+		Timeout:            time.Duration(req.Msg.TimeoutSeconds) * time.Second,
+		Force:              req.Msg.Force,
+	}
+
+	err := kh.ks.DrainNode(ctx, req.Msg.Context, req.Msg.Name, opts, func(event kubernetes.DrainEvent) error {
+		return stream.Send(&proto.DrainNodeReply{
+			Namespace: event.Namespace,
+			Pod:       event.Pod,
+			Type:      convertDrainEventType(event.Type),
+			Message:   event.Message,
+		})
+	})
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return newKubeError(err)
+	}
+
+	return nil
+}
+
+func convertDrainEventType(eventType kubernetes.DrainEventType) proto.DrainEventType {
+	switch eventType {
+	case kubernetes.DrainEventSkipped:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_SKIPPED
+	case kubernetes.DrainEventEvicting:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_EVICTING
+	case kubernetes.DrainEventRetrying:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_RETRYING
+	case kubernetes.DrainEventEvicted:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_EVICTED
+	case kubernetes.DrainEventDeleted:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_DELETED
+	case kubernetes.DrainEventFailed:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_FAILED
+	default:
+		return proto.DrainEventType_DRAIN_EVENT_TYPE_UNSPECIFIED
+	}
 }
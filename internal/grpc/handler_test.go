@@ -0,0 +1,134 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rneacsu/spyglass/internal/grpc/proto"
+	"github.com/rneacsu/spyglass/internal/grpc/proto/protoconnect"
+	"github.com/rneacsu/spyglass/internal/kubernetes"
+	"github.com/rneacsu/spyglass/internal/logger"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitGlobalLogger(false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newFakeAPIServer serves empty pod lists and never-ending watches of any namespace
+func newFakeAPIServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/") || !strings.HasSuffix(r.URL.Path, "/pods") {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("watch") == "true" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+
+		list := map[string]any{
+			"apiVersion": "v1",
+			"kind":       "PodList",
+			"metadata":   map[string]any{"resourceVersion": "1"},
+			"items":      []any{},
+		}
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			t.Errorf("failed to encode response: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newTestKubeClient serves a handler, talking to a fake API server as the test context, and returns a client of it
+func newTestKubeClient(t *testing.T) protoconnect.KubeClient {
+	dir := t.TempDir()
+	t.Setenv(kubernetes.CacheDirEnv, filepath.Join(dir, "cache"))
+	t.Setenv(kubernetes.ConfigDirEnv, filepath.Join(dir, "config"))
+
+	kubeConfig := api.NewConfig()
+	kubeConfig.Clusters["test"] = &api.Cluster{Server: newFakeAPIServer(t).URL}
+	kubeConfig.AuthInfos["test"] = api.NewAuthInfo()
+	kubeConfig.Contexts["test"] = &api.Context{Cluster: "test", AuthInfo: "test"}
+	kubeConfigPath := filepath.Join(dir, "kubeconfig")
+	if err := clientcmd.WriteToFile(*kubeConfig, kubeConfigPath); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, kubeConfigPath)
+
+	handler := NewKubeHandler()
+	mux := http.NewServeMux()
+	mux.Handle(protoconnect.NewKubeHandler(handler))
+	srv := httptest.NewUnstartedServer(mux)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	// Cleanups run last in first out, stop the watchers before the servers wait for their requests
+	t.Cleanup(srv.Close)
+	t.Cleanup(handler.Stop)
+
+	return protoconnect.NewKubeClient(srv.Client(), srv.URL)
+}
+
+// watchPods opens a pod stream of a namespace and waits for its snapshot
+func watchPods(t *testing.T, ctx context.Context, client protoconnect.KubeClient, namespace string) *connect.ServerStreamForClient[proto.WatchResourceReply] {
+	t.Helper()
+
+	stream, err := client.WatchResource(ctx, connect.NewRequest(&proto.ListResourceRequest{
+		Context:    "test",
+		Gvr:        &proto.GVR{Version: "v1", Resource: "pods"},
+		Namespaces: []string{namespace},
+	}))
+	if err != nil {
+		t.Fatalf("WatchResource(%s) error = %v", namespace, err)
+	}
+	t.Cleanup(func() { _ = stream.Close() })
+
+	if !stream.Receive() {
+		t.Fatalf("WatchResource(%s) ended before its snapshot: %v", namespace, stream.Err())
+	}
+	if got := stream.Msg().Type; got != proto.WatchEventType_WATCH_EVENT_TYPE_SNAPSHOT {
+		t.Fatalf("WatchResource(%s) first message type = %v, want a snapshot", namespace, got)
+	}
+
+	return stream
+}
+
+func TestWatchResourceEndsCleanlyWhenItsWatcherIsEvicted(t *testing.T) {
+	client := newTestKubeClient(t)
+	ctx, cancel := context.WithTimeout(t.Context(), 30*time.Second)
+	defer cancel()
+
+	evicted := watchPods(t, ctx, client, "evicted")
+
+	// Every watcher is streamed once the budget is full, the least recently used one makes room
+	for i := range kubernetes.MaxWatchers {
+		watchPods(t, ctx, client, fmt.Sprintf("other-%d", i))
+	}
+
+	if evicted.Receive() {
+		t.Fatalf("unexpected message %v, expected the stream to end", evicted.Msg())
+	}
+	if err := evicted.Err(); err != nil {
+		t.Fatalf("the stream of the evicted watcher ended with error %v, want none", err)
+	}
+}
@@ -0,0 +1,161 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FieldManager is the field manager owning the fields set through server-side apply
+const FieldManager = "spyglass"
+
+// ApplyAction is the outcome of applying an object
+type ApplyAction string
+
+const (
+	ApplyActionCreated    ApplyAction = "created"
+	ApplyActionConfigured ApplyAction = "configured"
+	ApplyActionUnchanged  ApplyAction = "unchanged"
+	ApplyActionError      ApplyAction = "error"
+)
+
+// ApplyOptions controls how conflicts are resolved and whether changes are persisted
+type ApplyOptions struct {
+	// Namespace of the namespaced objects without one, the namespace of the context when empty
+	Namespace string
+	// Force takes ownership of the fields managed by other field managers instead of failing
+	Force  bool
+	DryRun bool
+}
+
+// ApplyResult is the outcome of applying one object of a manifest. Object is the applied object, Err is set
+// when the action is ApplyActionError.
+type ApplyResult struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Action    ApplyAction
+	Object    *unstructured.Unstructured
+	Err       error
+}
+
+// ApplyManifest applies every object of a YAML or JSON manifest in order, through server-side apply. A
+// failing object does not prevent applying the following ones.
+func (kc *KubeConnection) ApplyManifest(ctx context.Context, manifest []byte, opts ApplyOptions) ([]ApplyResult, error) {
+	kc.UpdateLastUsed()
+
+	objects, err := decodeManifests(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Namespace == "" {
+		opts.Namespace = kc.defaultNamespace
+	}
+
+	results := make([]ApplyResult, 0, len(objects))
+	for _, obj := range objects {
+		result := kc.applyObject(ctx, obj, opts)
+		if result.Err != nil {
+			result.Action = ApplyActionError
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (kc *KubeConnection) applyObject(ctx context.Context, obj *unstructured.Unstructured, opts ApplyOptions) ApplyResult {
+	result := ApplyResult{
+		GVK:  obj.GroupVersionKind(),
+		Name: obj.GetName(),
+	}
+
+	if obj.GetName() == "" {
+		result.Err = apierrors.NewBadRequest("metadata.name is required")
+		return result
+	}
+
+	mapping, err := kc.restMapping(result.GVK)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(opts.Namespace)
+		}
+		result.Namespace = obj.GetNamespace()
+	}
+
+	// Objects copied from the cluster carry managed fields, which cannot be applied
+	obj.SetManagedFields(nil)
+
+	client := resourceClient(kc.dynamic, WatcherConfig{GVR: mapping.Resource, Namespace: result.Namespace})
+
+	existing, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		result.Err = err
+		return result
+	}
+
+	applyOpts := metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        opts.Force,
+	}
+	if opts.DryRun {
+		applyOpts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	applied, err := client.Apply(ctx, obj.GetName(), obj, applyOpts)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Object = applied
+	switch {
+	case existing == nil:
+		result.Action = ApplyActionCreated
+	case sameContent(existing, applied):
+		result.Action = ApplyActionUnchanged
+	default:
+		result.Action = ApplyActionConfigured
+	}
+
+	return result
+}
+
+// restMapping maps a kind to its resource, refreshing the discovery information once when the kind is
+// unknown, as it may have been defined since
+func (kc *KubeConnection) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := kc.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		kc.mapper.Reset()
+		mapping, err = kc.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if meta.IsNoMatchError(err) {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unknown kind %s: %v", gvk.String(), err))
+	}
+
+	return mapping, err
+}
+
+// sameContent reports whether two versions of an object are equal, ignoring the metadata maintained by the
+// API server on every write. Dry-run writes do not bump the resource version, so it cannot be relied upon.
+func sameContent(a *unstructured.Unstructured, b *unstructured.Unstructured) bool {
+	a, b = a.DeepCopy(), b.DeepCopy()
+	for _, obj := range []*unstructured.Unstructured{a, b} {
+		obj.SetResourceVersion("")
+		obj.SetManagedFields(nil)
+		obj.SetGeneration(0)
+	}
+	return equality.Semantic.DeepEqual(a.Object, b.Object)
+}
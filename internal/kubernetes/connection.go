@@ -1,17 +1,18 @@
 package kubernetes
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"net"
-	"path"
-	"regexp"
+	"sync"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery/cached/disk"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 )
@@ -20,23 +21,50 @@ const (
 	// DefaultDialTimeout is the default timeout for the connection
 	DefaultDialTimeout = 5 * time.Second
 
-	// MaxResourceWatchers is the maximum number of resource watchers that can be active at the same time
+	// MaxWatchers is the maximum number of list and table watchers that can be active at the same time
 	MaxWatchers = 10
+
+	// MaxResourceWatchers is the maximum number of single-object watchers that can be active at the same time.
+	// They have a budget of their own, so opening details panes never evicts the watchers behind the lists.
+	MaxResourceWatchers = 20
 )
 
+// ErrTooManyWatchers is returned when a watcher is needed while every watcher of its budget is in use by a request
+var ErrTooManyWatchers = errors.New("all watchers are in use")
+
 type KubeConnection struct {
-	kubeContext  string
-	clientConfig *rest.Config
-	watchers     map[string]Watcher
-	discovery    *disk.CachedDiscoveryClient
+	usageTracker
+
+	kubeContext      string
+	defaultNamespace string
+	clientConfig     *rest.Config
+	clientset        *clientset.Clientset
+	dynamic          dynamic.Interface
+	restClient       *rest.RESTClient
+	watchersLock     sync.Mutex
+	watchers         map[string]Watcher
+	discovery        *disk.CachedDiscoveryClient
+	mapper           *restmapper.DeferredDiscoveryRESTMapper
+	crds             *CRDWatcher
+	printers         *printerCache
+	customColumns    *customColumnStore
 
-	LastUsed time.Time
+	portForwardsLock sync.Mutex
+	portForwards     map[string]*portForward
 }
 
-func NewKubeConnection(kubeConfig *api.Config, kubeContext string) (*KubeConnection, error) {
-	clientConfig, err := clientcmd.NewDefaultClientConfig(*kubeConfig, &clientcmd.ConfigOverrides{
+func NewKubeConnection(kubeConfig *api.Config, kubeContext string, customColumns *customColumnStore) (*KubeConnection, error) {
+	contextConfig := clientcmd.NewDefaultClientConfig(*kubeConfig, &clientcmd.ConfigOverrides{
 		CurrentContext: kubeContext,
-	}).ClientConfig()
+	})
+
+	clientConfig, err := contextConfig.ClientConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defaultNamespace, _, err := contextConfig.Namespace()
 
 	if err != nil {
 		return nil, err
@@ -46,67 +74,77 @@ func NewKubeConnection(kubeConfig *api.Config, kubeContext string) (*KubeConnect
 		Timeout: DefaultDialTimeout,
 	}).DialContext
 
-	// TODO: use XDG Base Directory Specification
-	reg := regexp.MustCompile(`[:/\/<>?#]`)
-	cacheKey := reg.ReplaceAllString(clientConfig.Host, "_")
-	discoveryCacheDir := path.Join(clientcmd.RecommendedConfigDir, "cache", "discovery", cacheKey)
+	discoveryCacheDir, err := discoveryCacheDir(clientConfig.Host)
 
-	discoveryClient, err := disk.NewCachedDiscoveryClientForConfig(clientConfig, discoveryCacheDir, "", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := disk.NewCachedDiscoveryClientForConfig(clientConfig, discoveryCacheDir, "", DefaultDiscoveryCacheTTL)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &KubeConnection{
-		kubeContext:  kubeContext,
-		clientConfig: clientConfig,
-		watchers:     make(map[string]Watcher),
-		discovery:    discoveryClient,
-		LastUsed:     time.Now(),
-	}, nil
-}
+	clients, err := clientset.NewForConfig(clientConfig)
 
-func (kc *KubeConnection) Discover(ctx context.Context) ([]*metav1.APIResourceList, error) {
-	kc.LastUsed = time.Now()
+	if err != nil {
+		return nil, err
+	}
 
-	resources, err := kc.discovery.ServerPreferredResources()
+	// Share the REST client of the dynamic client for requests needing the raw response
+	dynamicConfig := dynamic.ConfigFor(clientConfig)
+	dynamicConfig.GroupVersion = nil
+	dynamicConfig.APIPath = ""
+	restClient, err := rest.UnversionedRESTClientFor(dynamicConfig)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return resources, nil
+	connection := &KubeConnection{
+		kubeContext:      kubeContext,
+		defaultNamespace: defaultNamespace,
+		clientConfig:     clientConfig,
+		clientset:        clients,
+		dynamic:          dynamic.New(restClient),
+		restClient:       restClient,
+		watchers:         make(map[string]Watcher),
+		discovery:        discoveryClient,
+		mapper:           restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient),
+		portForwards:     make(map[string]*portForward),
+		printers:         newPrinterCache(),
+		customColumns:    customColumns,
+	}
+	connection.crds = newCRDWatcher(connection.dynamic, kubeContext, connection.customResourcesChanged)
+	connection.UpdateLastUsed()
+
+	return connection, nil
 }
 
-func (kc *KubeConnection) GetWatcher(gvr schema.GroupVersionResource, namespace string, watcherType WatcherType) (Watcher, error) {
-	kc.LastUsed = time.Now()
+// GetWatcher returns the watcher matching the config, creating it if needed. The watcher is
+// protected from eviction until the caller releases it.
+func (kc *KubeConnection) GetWatcher(watcherConfig WatcherConfig, watcherType WatcherType) (Watcher, error) {
+	kc.UpdateLastUsed()
+
+	watcherConfig.KubeContext = kc.kubeContext
+	key := FormatWatcherID(watcherConfig, watcherType)
 
-	key := FormatWatcherID(gvr, namespace, watcherType)
+	kc.watchersLock.Lock()
+	defer kc.watchersLock.Unlock()
 
 	if watcher, ok := kc.watchers[key]; ok {
-		watcher.UpdateLastUsed()
+		watcher.acquire()
 		return watcher, nil
 	}
 
-	if len(kc.watchers) > MaxWatchers {
-		// Limit the number of watchers to avoid performance and rate limiting issues
-		var oldestWatcher Watcher
-		var oldestKey string
-		for k, w := range kc.watchers {
-			if oldestWatcher == nil || w.GetLastUsed().Before(oldestWatcher.GetLastUsed()) {
-				oldestWatcher = w
-				oldestKey = k
-			}
-		}
-
-		oldestWatcher.Stop()
-		delete(kc.watchers, oldestKey)
+	// Limit the number of watchers to avoid performance and rate limiting issues
+	limit := MaxWatchers
+	if watcherType == WatcherTypeResource {
+		limit = MaxResourceWatchers
 	}
-
-	watcherConfig := WatcherConfig{
-		KubeContext: kc.kubeContext,
-		GVR:         gvr,
-		Namespace:   namespace,
+	if err := kc.evictWatcher(watcherType == WatcherTypeResource, limit); err != nil {
+		return nil, err
 	}
 
 	var watcher Watcher
@@ -116,7 +154,11 @@ func (kc *KubeConnection) GetWatcher(gvr schema.GroupVersionResource, namespace
 	case WatcherTypeList:
 		watcher, err = NewListWatcher(kc.clientConfig, watcherConfig)
 	case WatcherTypeTable:
-		watcher, err = NewTableWatcher(kc.clientConfig, watcherConfig)
+		watcher, err = NewTableWatcher(kc.clientConfig, watcherConfig, kc.printers, func() *columnEvaluator {
+			return kc.customColumns.evaluator(watcherConfig.GVR)
+		})
+	case WatcherTypeResource:
+		watcher, err = NewResourceWatcher(kc.clientConfig, watcherConfig)
 	default:
 		err = fmt.Errorf("unsupported watcher type: %s", watcherType)
 	}
@@ -125,13 +167,162 @@ func (kc *KubeConnection) GetWatcher(gvr schema.GroupVersionResource, namespace
 		return nil, err
 	}
 
+	watcher.acquire()
 	kc.watchers[key] = watcher
 
 	return watcher, nil
 }
 
-func (kc *KubeConnection) Stop() {
+// evictWatcher stops the least recently used watcher not in use by a request when the watchers sharing a
+// budget reach the limit, preferring idle watchers over streamed ones. Evicting a streamed watcher ends its
+// streams. Single-object watchers and list or table watchers have separate budgets. Must be called with
+// watchersLock held.
+func (kc *KubeConnection) evictWatcher(singleObject bool, limit int) error {
+	count := 0
+	var oldestWatcher Watcher
+	var oldestKey string
+	for k, w := range kc.watchers {
+		if (w.GetType() == WatcherTypeResource) != singleObject {
+			continue
+		}
+		count++
+		if w.inUse() {
+			continue
+		}
+		if oldestWatcher == nil || evictsBefore(w, oldestWatcher) {
+			oldestWatcher = w
+			oldestKey = k
+		}
+	}
+
+	if count < limit {
+		return nil
+	}
+
+	if oldestWatcher == nil {
+		return fmt.Errorf("%w (context: %s, limit: %d)", ErrTooManyWatchers, kc.kubeContext, limit)
+	}
+
+	delete(kc.watchers, oldestKey)
+	// Stopping waits for the background watch, do not block other requests meanwhile
+	go oldestWatcher.Stop()
+
+	return nil
+}
+
+// evictsBefore reports whether a watcher is evicted before another one
+func evictsBefore(a Watcher, b Watcher) bool {
+	if a.streamed() != b.streamed() {
+		return !a.streamed()
+	}
+	return a.GetLastUsed().Before(b.GetLastUsed())
+}
+
+// GetQueryWatchers returns one watcher per namespace of the query, no namespaces meaning a single cluster-wide watcher.
+// Every returned watcher must be released by the caller.
+func (kc *KubeConnection) GetQueryWatchers(query ResourceQuery, watcherType WatcherType) ([]Watcher, error) {
+	configs := kc.queryConfigs(query)
+	watchers := make([]Watcher, 0, len(configs))
+	for _, config := range configs {
+		watcher, err := kc.GetWatcher(config, watcherType)
+
+		if err != nil {
+			releaseWatchers(watchers)
+			return nil, err
+		}
+		watchers = append(watchers, watcher)
+	}
+
+	return watchers, nil
+}
+
+// queryConfigs returns the watcher config of every namespace of the query, no namespaces meaning a single
+// cluster-wide config
+func (kc *KubeConnection) queryConfigs(query ResourceQuery) []WatcherConfig {
+	namespaces := query.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	configs := make([]WatcherConfig, 0, len(namespaces))
+	seen := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		if seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+
+		configs = append(configs, WatcherConfig{
+			KubeContext:   kc.kubeContext,
+			GVR:           query.GVR,
+			Namespace:     namespace,
+			LabelSelector: query.LabelSelector,
+			FieldSelector: query.FieldSelector,
+		})
+	}
+
+	return configs
+}
+
+// RefreshCustomColumns relists the table watchers of a resource, so their rows follow its custom columns
+func (kc *KubeConnection) RefreshCustomColumns(gvr schema.GroupVersionResource) {
+	// Relisting waits for a list in progress, do not block the watchers meanwhile
+	for _, tw := range kc.tableWatchers() {
+		if tw.config.GVR == gvr {
+			tw.RefreshCustomColumns()
+		}
+	}
+}
+
+// customResourcesChanged follows a change to the custom resource definitions, which may change the served
+// resources and the printer columns of their tables. The printers are resolved again as the table watchers
+// relist.
+func (kc *KubeConnection) customResourcesChanged() {
+	kc.InvalidateDiscovery()
+	kc.printers.reset()
+
+	for _, tw := range kc.tableWatchers() {
+		tw.relist()
+	}
+}
+
+// tableWatchers returns the current table watchers of the connection
+func (kc *KubeConnection) tableWatchers() []*TableWatcher {
+	kc.watchersLock.Lock()
+	defer kc.watchersLock.Unlock()
+
+	tableWatchers := make([]*TableWatcher, 0)
 	for _, watcher := range kc.watchers {
-		watcher.Stop()
+		if tw, ok := watcher.(*TableWatcher); ok {
+			tableWatchers = append(tableWatchers, tw)
+		}
+	}
+	return tableWatchers
+}
+
+// Stop stops the watchers, including the watch of custom resource definitions, and port forwards of the connection
+func (kc *KubeConnection) Stop() {
+	kc.watchersLock.Lock()
+	watchers := kc.watchers
+	kc.watchers = make(map[string]Watcher)
+	kc.watchersLock.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		kc.stopPortForwards()
+	}()
+	go func() {
+		defer wg.Done()
+		kc.crds.Stop()
+	}()
+	for _, watcher := range watchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watcher.Stop()
+		}()
 	}
+	wg.Wait()
 }
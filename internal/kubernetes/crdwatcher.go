@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// CRDWatcher watches the custom resource definitions of a connection, calling onChange whenever the served
+// resources may have changed: a definition was added or deleted, or its spec changed. Status updates, such
+// as the accepted names or conditions, leave the generation alone and are ignored.
+// It is owned by the connection rather than the watcher budget, so it is never evicted.
+type CRDWatcher struct {
+	*baseWatcher
+	client   dynamic.Interface
+	onChange func()
+
+	// generations of the known definitions, only used by the background watch loop and the list it
+	// starts from, which never run concurrently
+	generations map[types.UID]int64
+}
+
+func newCRDWatcher(client dynamic.Interface, kubeContext string, onChange func()) *CRDWatcher {
+	return &CRDWatcher{
+		baseWatcher: NewBaseWatcher(WatcherConfig{KubeContext: kubeContext, GVR: crdsGVR}, WatcherTypeList),
+		client:      client,
+		onChange:    onChange,
+	}
+}
+
+// Start lists the definitions and starts watching them in the background, unless already watching. The
+// watch ends if it cannot be resumed, starting again restarts it.
+func (cw *CRDWatcher) Start() error {
+	cw.watchLock.Lock()
+	defer cw.watchLock.Unlock()
+
+	return cw.ensureWatch(cw.ctx, cw)
+}
+
+func (cw *CRDWatcher) list(ctx context.Context) (string, error) {
+	list, err := cw.client.Resource(crdsGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list custom resource definitions (context: %s): %w", cw.config.KubeContext, err)
+	}
+
+	generations := make(map[types.UID]int64, len(list.Items))
+	for i := range list.Items {
+		generations[list.Items[i].GetUID()] = list.Items[i].GetGeneration()
+	}
+
+	// The first list matches the discovery information, a relist only changes it if definitions changed
+	// while not watching
+	changed := cw.generations != nil && !sameGenerations(cw.generations, generations)
+	cw.generations = generations
+	if changed {
+		cw.onChange()
+	}
+
+	return list.GetResourceVersion(), nil
+}
+
+func (cw *CRDWatcher) startWatch(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	timeout := int64(DefaultWatchTimeout.Seconds())
+	watcher, err := cw.client.Resource(crdsGVR).Watch(ctx, metav1.ListOptions{
+		ResourceVersion:     resourceVersion,
+		TimeoutSeconds:      &timeout,
+		AllowWatchBookmarks: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch custom resource definitions (context: %s): %w", cw.config.KubeContext, err)
+	}
+
+	return watcher, nil
+}
+
+func (cw *CRDWatcher) handleEvent(event watch.Event) {
+	switch event.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		obj := event.Object.(*unstructured.Unstructured)
+		generation, known := cw.generations[obj.GetUID()]
+
+		if event.Type == watch.Deleted {
+			delete(cw.generations, obj.GetUID())
+		} else {
+			cw.generations[obj.GetUID()] = obj.GetGeneration()
+			if known && generation == obj.GetGeneration() {
+				return
+			}
+		}
+
+		cw.onChange()
+	case watch.Error:
+		logger.Errorw(watchErrorMessage(event), cw.logContext...)
+	}
+}
+
+func (cw *CRDWatcher) resync() {
+}
+
+// sameGenerations reports whether two sets of definitions have the same members and generations
+func sameGenerations(a map[types.UID]int64, b map[types.UID]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for uid, generation := range a {
+		if other, ok := b[uid]; !ok || other != generation {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestCRD(name string, generation int64) *unstructured.Unstructured {
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName(name)
+	crd.SetUID(types.UID("uid-" + name))
+	crd.SetGeneration(generation)
+	return crd
+}
+
+// waitForCount waits for a counter to reach a value
+func waitForCount(t *testing.T, counter *atomic.Int32, want int32, what string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for counter.Load() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got %d changes, want %d", what, counter.Load(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCRDWatcherOnlyReportsServedResourceChanges(t *testing.T) {
+	client := newFakeDynamicClient(newTestCRD("foos.example.com", 1))
+	watches := newControlledWatches(client)
+
+	var changes atomic.Int32
+	cw := newCRDWatcher(client, "test", func() { changes.Add(1) })
+	defer cw.Stop()
+
+	if err := cw.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, watches.started, "the first watch")
+	if got := changes.Load(); got != 0 {
+		t.Fatalf("the initial list reported %d changes, want none", got)
+	}
+
+	watches.lock.Lock()
+	w := watches.watches[0]
+	watches.lock.Unlock()
+
+	// A status update keeps the generation, only the spec change that follows is reported
+	status := newTestCRD("foos.example.com", 1)
+	if err := unstructured.SetNestedField(status.Object, "True", "status", "conditions", "established"); err != nil {
+		t.Fatal(err)
+	}
+	w.Modify(status)
+	w.Modify(newTestCRD("foos.example.com", 2))
+	waitForCount(t, &changes, 1, "spec change")
+
+	w.Add(newTestCRD("bars.example.com", 1))
+	waitForCount(t, &changes, 2, "added definition")
+
+	w.Delete(newTestCRD("bars.example.com", 1))
+	waitForCount(t, &changes, 3, "deleted definition")
+
+	// A definition deleted while not watching is reported by the relist
+	if err := client.Resource(crdsGVR).Delete(context.Background(), "foos.example.com", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	watches.end()
+
+	// The watch resumes once the relist is done, no change is pending anymore
+	waitFor(t, watches.started, "the watch to be resumed")
+	if got := changes.Load(); got != 4 {
+		t.Fatalf("got %d changes after the relist, want 4", got)
+	}
+}
@@ -0,0 +1,480 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"github.com/rneacsu/spyglass/internal/logger"
+	"google.golang.org/protobuf/types/known/structpb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CustomColumnsFile is the file of the custom columns in the configuration directory
+	CustomColumnsFile = "columns.yaml"
+
+	// customColumnCostLimit bounds the evaluation of CEL expressions, so a costly expression cannot stall listing
+	customColumnCostLimit = 1000000
+
+	// customColumnNone is the cell of a value missing from the object
+	customColumnNone = "<none>"
+	// customColumnInvalid is the cell of an expression failing on the object
+	customColumnInvalid = "<invalid>"
+)
+
+// celEnv declares the object variable the CEL expressions of custom columns are evaluated against. Optional
+// types let expressions select fields that may be missing, as in object.?spec.?nodeName.
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.OptionalTypes(),
+		ext.Strings(),
+	)
+})
+
+// CustomColumn is a column defined by the user, evaluated against the full objects. Exactly one of
+// JSONPath and CEL is set.
+type CustomColumn struct {
+	Name string `json:"name"`
+	// JSONPath in the syntax of kubectl custom columns, the braces being optional
+	JSONPath string `json:"jsonPath,omitempty"`
+	// CEL expression, the object being bound to the object variable. Fields that may be missing are selected
+	// with has() or the optional syntax, an empty optional rendering as none.
+	CEL string `json:"cel,omitempty"`
+}
+
+// CustomColumnSet is the custom columns of a resource, appended to its table in order
+type CustomColumnSet struct {
+	Group    string         `json:"group"`
+	Version  string         `json:"version"`
+	Resource string         `json:"resource"`
+	Columns  []CustomColumn `json:"columns"`
+}
+
+func (s *CustomColumnSet) GVR() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: s.Group, Version: s.Version, Resource: s.Resource}
+}
+
+// CustomColumnPreset is the format of the custom columns file, which can be shared and imported as is
+type CustomColumnPreset struct {
+	Resources []CustomColumnSet `json:"resources"`
+}
+
+// customColumnStore holds the custom columns of every resource, persisted in the configuration directory
+type customColumnStore struct {
+	lock       sync.RWMutex
+	path       string
+	sets       map[schema.GroupVersionResource][]CustomColumn
+	evaluators map[schema.GroupVersionResource]*columnEvaluator
+}
+
+// newCustomColumnStore loads the custom columns file. A missing or invalid file results in no columns.
+func newCustomColumnStore() *customColumnStore {
+	cs := &customColumnStore{
+		sets:       make(map[schema.GroupVersionResource][]CustomColumn),
+		evaluators: make(map[schema.GroupVersionResource]*columnEvaluator),
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		logger.Warnw("could not find the configuration directory, custom columns will not be saved", "error", err)
+		return cs
+	}
+	cs.path = filepath.Join(dir, CustomColumnsFile)
+
+	data, err := os.ReadFile(cs.path)
+	if os.IsNotExist(err) {
+		return cs
+	}
+	if err == nil {
+		err = cs.load(data, true)
+	}
+	if err != nil {
+		logger.Warnw("could not load custom columns", "path", cs.path, "error", err)
+	}
+
+	return cs
+}
+
+// load adds the columns of a preset, replacing the columns of the resources it defines, or all columns
+// when replace is set
+func (cs *customColumnStore) load(data []byte, replace bool) error {
+	var preset CustomColumnPreset
+	if err := yaml.UnmarshalStrict(data, &preset); err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid custom columns: %v", err))
+	}
+
+	sets := make(map[schema.GroupVersionResource][]CustomColumn)
+	evaluators := make(map[schema.GroupVersionResource]*columnEvaluator)
+	for i := range preset.Resources {
+		set := &preset.Resources[i]
+		if _, ok := evaluators[set.GVR()]; ok {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid custom columns: %s defined twice", set.GVR()))
+		}
+
+		evaluator, err := compileColumns(set.GVR(), set.Columns)
+		if err != nil {
+			return err
+		}
+		sets[set.GVR()] = set.Columns
+		evaluators[set.GVR()] = evaluator
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if replace {
+		cs.sets = make(map[schema.GroupVersionResource][]CustomColumn)
+		cs.evaluators = make(map[schema.GroupVersionResource]*columnEvaluator)
+	}
+	for gvr, columns := range sets {
+		cs.setLocked(gvr, columns, evaluators[gvr])
+	}
+
+	return nil
+}
+
+// setLocked replaces the columns of a resource, no columns removing them. Must be called with lock held.
+func (cs *customColumnStore) setLocked(gvr schema.GroupVersionResource, columns []CustomColumn, evaluator *columnEvaluator) {
+	if len(columns) == 0 {
+		delete(cs.sets, gvr)
+		delete(cs.evaluators, gvr)
+		return
+	}
+	cs.sets[gvr] = columns
+	cs.evaluators[gvr] = evaluator
+}
+
+// List returns the custom columns of every resource, sorted by resource
+func (cs *customColumnStore) List() []CustomColumnSet {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.listLocked()
+}
+
+func (cs *customColumnStore) listLocked() []CustomColumnSet {
+	sets := make([]CustomColumnSet, 0, len(cs.sets))
+	for gvr, columns := range cs.sets {
+		sets = append(sets, CustomColumnSet{
+			Group:    gvr.Group,
+			Version:  gvr.Version,
+			Resource: gvr.Resource,
+			Columns:  columns,
+		})
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].GVR().String() < sets[j].GVR().String()
+	})
+
+	return sets
+}
+
+// Set replaces the custom columns of a resource and saves them, no columns removing them
+func (cs *customColumnStore) Set(gvr schema.GroupVersionResource, columns []CustomColumn) error {
+	evaluator, err := compileColumns(gvr, columns)
+	if err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.setLocked(gvr, columns, evaluator)
+
+	return cs.saveLocked()
+}
+
+// Import loads a preset and saves the result, replacing the columns of the resources it defines, or all
+// columns when replace is set
+func (cs *customColumnStore) Import(preset []byte, replace bool) error {
+	if err := cs.load(preset, replace); err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	return cs.saveLocked()
+}
+
+// Export returns the custom columns of every resource as a preset
+func (cs *customColumnStore) Export() ([]byte, error) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return yaml.Marshal(CustomColumnPreset{Resources: cs.listLocked()})
+}
+
+// saveLocked writes the custom columns file, replacing it atomically. Must be called with lock held.
+func (cs *customColumnStore) saveLocked() error {
+	if cs.path == "" {
+		return apierrors.NewServiceUnavailable("no configuration directory to save custom columns to")
+	}
+
+	data, err := yaml.Marshal(CustomColumnPreset{Resources: cs.listLocked()})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cs.path), 0o755); err != nil {
+		return fmt.Errorf("failed to save custom columns: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cs.path), CustomColumnsFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save custom columns: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to save custom columns: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save custom columns: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cs.path); err != nil {
+		return fmt.Errorf("failed to save custom columns: %w", err)
+	}
+
+	return nil
+}
+
+// evaluator returns the evaluator of the custom columns of a resource, nil if it has none
+func (cs *customColumnStore) evaluator(gvr schema.GroupVersionResource) *columnEvaluator {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.evaluators[gvr]
+}
+
+// columnEvaluator appends the custom columns of a resource to its tables, evaluating them against the full
+// objects carried by the rows
+type columnEvaluator struct {
+	columns []metav1.TableColumnDefinition
+	evals   []func(obj map[string]any) string
+}
+
+// compileColumns validates and compiles the custom columns of a resource
+func compileColumns(gvr schema.GroupVersionResource, columns []CustomColumn) (*columnEvaluator, error) {
+	if gvr.Version == "" || gvr.Resource == "" {
+		return nil, apierrors.NewBadRequest("invalid custom columns: version and resource are required")
+	}
+
+	ce := &columnEvaluator{
+		columns: make([]metav1.TableColumnDefinition, 0, len(columns)),
+		evals:   make([]func(obj map[string]any) string, 0, len(columns)),
+	}
+
+	names := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.Name == "" {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid custom columns of %s: name is required", gvr))
+		}
+		if names[column.Name] {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid custom columns of %s: column %s defined twice", gvr, column.Name))
+		}
+		names[column.Name] = true
+
+		var eval func(obj map[string]any) string
+		var err error
+		switch {
+		case column.JSONPath != "" && column.CEL != "":
+			err = fmt.Errorf("only one of jsonPath and cel can be set")
+		case column.JSONPath != "":
+			eval, err = compileJSONPathColumn(column)
+		case column.CEL != "":
+			eval, err = compileCELColumn(column)
+		default:
+			err = fmt.Errorf("one of jsonPath and cel is required")
+		}
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid custom column %s of %s: %v", column.Name, gvr, err))
+		}
+
+		ce.columns = append(ce.columns, metav1.TableColumnDefinition{
+			Name: column.Name,
+			Type: "string",
+		})
+		ce.evals = append(ce.evals, eval)
+	}
+
+	return ce, nil
+}
+
+// compileJSONPathColumn compiles a JSONPath column, rendering multiple results comma-separated as kubectl does
+func compileJSONPathColumn(column CustomColumn) (func(obj map[string]any) string, error) {
+	expression := strings.TrimSpace(column.JSONPath)
+	expression = strings.TrimSuffix(strings.TrimPrefix(expression, "{"), "}")
+	if !strings.HasPrefix(expression, ".") {
+		expression = "." + expression
+	}
+
+	path := jsonpath.New(column.Name)
+	path.AllowMissingKeys(true)
+	if err := path.Parse("{" + expression + "}"); err != nil {
+		return nil, err
+	}
+
+	// JSONPaths keep state while evaluated
+	var lock sync.Mutex
+	return func(obj map[string]any) string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		results, err := path.FindResults(obj)
+		if err != nil {
+			return customColumnInvalid
+		}
+
+		values := make([]string, 0, 1)
+		for _, result := range results {
+			for _, value := range result {
+				values = append(values, fmt.Sprintf("%v", value.Interface()))
+			}
+		}
+		if len(values) == 0 {
+			return customColumnNone
+		}
+
+		return strings.Join(values, ",")
+	}, nil
+}
+
+// compileCELColumn compiles a CEL column. Strings are rendered as is, other values as JSON.
+func compileCELColumn(column CustomColumn) (func(obj map[string]any) string, error) {
+	env, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(column.CEL)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	program, err := env.Program(ast, cel.CostLimit(customColumnCostLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(obj map[string]any) string {
+		out, _, err := program.Eval(map[string]any{"object": obj})
+		if err != nil {
+			return customColumnInvalid
+		}
+
+		return formatCELValue(out)
+	}, nil
+}
+
+func formatCELValue(value ref.Val) string {
+	switch typed := value.(type) {
+	case types.String:
+		return string(typed)
+	case types.Null:
+		return customColumnNone
+	case *types.Optional:
+		if !typed.HasValue() {
+			return customColumnNone
+		}
+		return formatCELValue(typed.GetValue())
+	}
+
+	native, err := value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return fmt.Sprintf("%v", value.Value())
+	}
+
+	data, err := json.Marshal(native.(*structpb.Value).AsInterface())
+	if err != nil {
+		return fmt.Sprintf("%v", value.Value())
+	}
+
+	return string(data)
+}
+
+// table returns a copy of a table with the custom columns appended, evaluated against the full objects
+// carried by the rows. A nil evaluator returns the table as is.
+func (ce *columnEvaluator) table(table *metav1.Table) *metav1.Table {
+	if ce == nil {
+		return table
+	}
+
+	result := &metav1.Table{
+		ListMeta:          table.ListMeta,
+		ColumnDefinitions: ce.columnDefinitions(table.ColumnDefinitions),
+		Rows:              make([]metav1.TableRow, 0, len(table.Rows)),
+	}
+	for _, row := range table.Rows {
+		result.Rows = append(result.Rows, ce.row(row))
+	}
+
+	return result
+}
+
+// columnDefinitions returns a copy of the column definitions of a table with the custom columns appended
+func (ce *columnEvaluator) columnDefinitions(columns []metav1.TableColumnDefinition) []metav1.TableColumnDefinition {
+	if ce == nil {
+		return columns
+	}
+
+	result := make([]metav1.TableColumnDefinition, 0, len(columns)+len(ce.columns))
+	result = append(result, columns...)
+	return append(result, ce.columns...)
+}
+
+// row returns a copy of a row with the cells of the custom columns appended, evaluated against the full
+// object it carries. The object is dropped once evaluated, only its metadata is kept.
+func (ce *columnEvaluator) row(row metav1.TableRow) metav1.TableRow {
+	if ce == nil {
+		return row
+	}
+
+	// Integers are decoded as int64, as in the objects of the dynamic client
+	var obj map[string]any
+	if err := utiljson.Unmarshal(row.Object.Raw, &obj); err != nil {
+		obj = nil
+	}
+	row.Object.Raw = nil
+
+	return ce.objectRow(row, obj)
+}
+
+// objectRow returns a copy of a row with the cells of the custom columns appended, evaluated against the
+// given object
+func (ce *columnEvaluator) objectRow(row metav1.TableRow, obj map[string]any) metav1.TableRow {
+	if ce == nil {
+		return row
+	}
+
+	cells := make([]any, 0, len(row.Cells)+len(ce.evals))
+	cells = append(cells, row.Cells...)
+	for _, eval := range ce.evals {
+		if obj == nil {
+			cells = append(cells, customColumnNone)
+		} else {
+			cells = append(cells, eval(obj))
+		}
+	}
+
+	row.Cells = cells
+	return row
+}
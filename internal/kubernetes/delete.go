@@ -0,0 +1,171 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DeleteOptions controls how the dependents of deleted objects are handled and the time given to objects
+// to terminate
+type DeleteOptions struct {
+	// Propagation policy for the dependents, the default of the resource when empty
+	Propagation        metav1.DeletionPropagation
+	GracePeriodSeconds *int64
+	DryRun             bool
+}
+
+// DeleteResult is the outcome of deleting one object. Removed tells whether the object is gone, otherwise
+// its deletion waits on finalizers or graceful termination and Object is its current state. On dry-run,
+// Removed tells whether the object would be gone.
+type DeleteResult struct {
+	Namespace string
+	Name      string
+	UID       types.UID
+	Removed   bool
+	Object    *unstructured.Unstructured
+	Err       error
+}
+
+// DeleteResource deletes a single object
+func (kc *KubeConnection) DeleteResource(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string, opts DeleteOptions) (DeleteResult, error) {
+	kc.UpdateLastUsed()
+
+	result := kc.deleteObject(ctx, gvr, namespace, name, "", opts)
+	if result.Err != nil {
+		return DeleteResult{}, fmt.Errorf("failed to delete (context: %s, resource: %s, namespace: %s, name: %s): %w", kc.kubeContext, gvr, namespace, name, result.Err)
+	}
+
+	return result, nil
+}
+
+// DeleteResources deletes every object matching the query, which must have a label or field selector. A
+// failing object does not prevent deleting the following ones.
+func (kc *KubeConnection) DeleteResources(ctx context.Context, query ResourceQuery, opts DeleteOptions) ([]DeleteResult, error) {
+	kc.UpdateLastUsed()
+
+	if query.LabelSelector == "" && query.FieldSelector == "" {
+		return nil, apierrors.NewBadRequest("deleting multiple resources requires a label or field selector")
+	}
+
+	namespaces := query.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	objects := make([]unstructured.Unstructured, 0)
+	for _, namespace := range namespaces {
+		list, err := resourceClient(kc.dynamic, WatcherConfig{GVR: query.GVR, Namespace: namespace}).List(ctx, metav1.ListOptions{
+			LabelSelector: query.LabelSelector,
+			FieldSelector: query.FieldSelector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list (context: %s, resource: %s, namespace: %s): %w", kc.kubeContext, query.GVR, namespace, err)
+		}
+		objects = append(objects, list.Items...)
+	}
+
+	results := make([]DeleteResult, 0, len(objects))
+	for _, obj := range objects {
+		results = append(results, kc.deleteObject(ctx, query.GVR, obj.GetNamespace(), obj.GetName(), obj.GetUID(), opts))
+	}
+
+	return results, nil
+}
+
+// deleteObject deletes an object, only if it still has the given UID when set. Removed objects are
+// dropped from the watcher caches right away.
+func (kc *KubeConnection) deleteObject(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string, uid types.UID, opts DeleteOptions) DeleteResult {
+	result := DeleteResult{
+		Namespace: namespace,
+		Name:      name,
+		UID:       uid,
+	}
+
+	deleteOpts := metav1.DeleteOptions{
+		GracePeriodSeconds: opts.GracePeriodSeconds,
+	}
+	if opts.Propagation != "" {
+		deleteOpts.PropagationPolicy = &opts.Propagation
+	}
+	if opts.DryRun {
+		deleteOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	if uid != "" {
+		deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+
+	raw, err := kc.restClient.Delete().
+		AbsPath(resourcePath(gvr, namespace, name)...).
+		Body(&deleteOpts).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// The API server answers with a status once the object is gone, and with the object while its deletion
+	// is pending
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if obj.GetKind() == "Status" {
+		var status metav1.Status
+		if err := json.Unmarshal(raw, &status); err != nil {
+			result.Err = err
+			return result
+		}
+		if status.Details != nil && status.Details.UID != "" {
+			result.UID = status.Details.UID
+		}
+		result.Removed = true
+	} else {
+		result.UID = obj.GetUID()
+		result.Object = obj
+	}
+
+	if result.Removed && !opts.DryRun && result.UID != "" {
+		kc.objectDeleted(result.UID)
+	}
+
+	return result
+}
+
+// objectDeleted drops a deleted object from the caches of all watchers of the connection
+func (kc *KubeConnection) objectDeleted(uid types.UID) {
+	kc.watchersLock.Lock()
+	watchers := make([]Watcher, 0, len(kc.watchers))
+	for _, watcher := range kc.watchers {
+		watchers = append(watchers, watcher)
+	}
+	kc.watchersLock.Unlock()
+
+	for _, watcher := range watchers {
+		watcher.objectDeleted(uid)
+	}
+}
+
+// resourcePath returns the API path segments of a named object
+func resourcePath(gvr schema.GroupVersionResource, namespace string, name string) []string {
+	segments := []string{"api"}
+	if gvr.Group != "" {
+		segments = []string{"apis", gvr.Group}
+	}
+	segments = append(segments, gvr.Version)
+
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+
+	return append(segments, gvr.Resource, name)
+}
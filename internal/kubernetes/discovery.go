@@ -0,0 +1,213 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	// DefaultDiscoveryCacheTTL is the time the discovery information is cached on disk before being fetched again
+	DefaultDiscoveryCacheTTL = 10 * time.Minute
+)
+
+var (
+	crdsGroupResource = schema.GroupResource{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}
+
+	cacheKeyRegexp = regexp.MustCompile(`[:/\/<>?#]`)
+)
+
+// Discovery describes the API groups served by a cluster and the resources of every served version. The
+// versions whose resources could not be discovered, such as those of an unavailable aggregated API, are
+// reported in Failed instead of failing the whole discovery.
+type Discovery struct {
+	Groups []DiscoveredGroup
+	APIs   []DiscoveredAPI
+	Failed []FailedAPI
+}
+
+// FailedAPI is a served version whose resources could not be discovered
+type FailedAPI struct {
+	Group   string
+	Version string
+	Err     error
+}
+
+// DiscoveredGroup is an API group with its served versions, ordered by priority
+type DiscoveredGroup struct {
+	Name             string
+	Versions         []string
+	PreferredVersion string
+}
+
+// DiscoveredAPI is a served version of an API group
+type DiscoveredAPI struct {
+	Group   string
+	Version string
+	// Preferred tells whether this is the preferred version of the group
+	Preferred bool
+	Resources []DiscoveredResource
+}
+
+// DiscoveredResource is a resource of a served version. Preferred tells whether the resource is preferred
+// in this version, which is the preferred version of the group unless the resource is not served in it.
+type DiscoveredResource struct {
+	metav1.APIResource
+	Subresources []string
+	Preferred    bool
+}
+
+func (kc *KubeConnection) Discover(ctx context.Context) (*Discovery, error) {
+	kc.UpdateLastUsed()
+	kc.watchCRDs()
+
+	groups, resourceLists, err := kc.discovery.ServerGroupsAndResources()
+
+	var groupErr *discovery.ErrGroupDiscoveryFailed
+	if err != nil && !errors.As(err, &groupErr) {
+		return nil, fmt.Errorf("failed to discover (context: %s): %w", kc.kubeContext, err)
+	}
+
+	result := buildDiscovery(groups, resourceLists)
+	if groupErr != nil {
+		result.Failed = failedAPIs(groupErr)
+		logger.Warnw("some API groups could not be discovered", "context", kc.kubeContext, "error", groupErr)
+	}
+
+	return result, nil
+}
+
+// failedAPIs returns the versions that failed a partial discovery, sorted by group version
+func failedAPIs(groupErr *discovery.ErrGroupDiscoveryFailed) []FailedAPI {
+	failed := make([]FailedAPI, 0, len(groupErr.Groups))
+	for gv, err := range groupErr.Groups {
+		failed = append(failed, FailedAPI{
+			Group:   gv.Group,
+			Version: gv.Version,
+			Err:     err,
+		})
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		if failed[i].Group != failed[j].Group {
+			return failed[i].Group < failed[j].Group
+		}
+		return failed[i].Version < failed[j].Version
+	})
+
+	return failed
+}
+
+// InvalidateDiscovery drops the cached discovery information, so that it is fetched again on next use
+func (kc *KubeConnection) InvalidateDiscovery() {
+	// Resetting the mapper also invalidates the discovery client it is backed by
+	kc.mapper.Reset()
+}
+
+// watchCRDs starts watching the custom resource definitions in the background unless already watching, so
+// the discovery information follows them without a restart
+func (kc *KubeConnection) watchCRDs() {
+	go func() {
+		if err := kc.crds.Start(); err != nil && !kc.crds.isStopped() {
+			logger.Warnw("failed to watch custom resource definitions, discovery will not follow them", "context", kc.kubeContext, "error", err)
+		}
+	}()
+}
+
+// discoveryCacheDir returns the directory of the discovery cache of an API server
+func discoveryCacheDir(host string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "discovery", cacheKeyRegexp.ReplaceAllString(host, "_")), nil
+}
+
+func buildDiscovery(groups []*metav1.APIGroup, resourceLists []*metav1.APIResourceList) *Discovery {
+	resourcesByGV := make(map[string]*metav1.APIResourceList, len(resourceLists))
+	for _, resourceList := range resourceLists {
+		resourcesByGV[resourceList.GroupVersion] = resourceList
+	}
+
+	result := &Discovery{
+		Groups: make([]DiscoveredGroup, 0, len(groups)),
+		APIs:   make([]DiscoveredAPI, 0, len(resourceLists)),
+	}
+
+	for _, group := range groups {
+		discoveredGroup := DiscoveredGroup{
+			Name:             group.Name,
+			Versions:         make([]string, 0, len(group.Versions)),
+			PreferredVersion: group.PreferredVersion.Version,
+		}
+
+		// A resource is preferred in the preferred version of the group, otherwise in the first version serving
+		// it, versions being ordered by priority
+		versions := make([]metav1.GroupVersionForDiscovery, 0, len(group.Versions))
+		versions = append(versions, group.PreferredVersion)
+		for _, version := range group.Versions {
+			discoveredGroup.Versions = append(discoveredGroup.Versions, version.Version)
+			if version.Version != group.PreferredVersion.Version {
+				versions = append(versions, version)
+			}
+		}
+
+		preferredResources := make(map[string]bool)
+		for _, version := range versions {
+			resourceList, ok := resourcesByGV[version.GroupVersion]
+			if !ok {
+				continue
+			}
+
+			api := DiscoveredAPI{
+				Group:     group.Name,
+				Version:   version.Version,
+				Preferred: version.Version == group.PreferredVersion.Version,
+				Resources: discoverResources(resourceList.APIResources),
+			}
+			for i := range api.Resources {
+				if !preferredResources[api.Resources[i].Name] {
+					preferredResources[api.Resources[i].Name] = true
+					api.Resources[i].Preferred = true
+				}
+			}
+
+			result.APIs = append(result.APIs, api)
+		}
+
+		result.Groups = append(result.Groups, discoveredGroup)
+	}
+
+	return result
+}
+
+// discoverResources returns the resources of a version, with their subresources attached instead of
+// being listed on their own
+func discoverResources(apiResources []metav1.APIResource) []DiscoveredResource {
+	resources := make([]DiscoveredResource, 0, len(apiResources))
+	subresources := make(map[string][]string)
+	for _, apiResource := range apiResources {
+		if resource, subresource, ok := strings.Cut(apiResource.Name, "/"); ok {
+			subresources[resource] = append(subresources[resource], subresource)
+			continue
+		}
+		resources = append(resources, DiscoveredResource{APIResource: apiResource})
+	}
+
+	for i := range resources {
+		resources[i].Subresources = subresources[resources[i].Name]
+	}
+
+	return resources
+}
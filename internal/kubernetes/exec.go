@@ -0,0 +1,118 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// TerminalSize is the size of an interactive terminal, in characters
+type TerminalSize = remotecommand.TerminalSize
+
+// ExecOptions selects the container and the command to run in it
+type ExecOptions struct {
+	Container string
+	Command   []string
+	Stdin     bool
+	TTY       bool
+}
+
+// ExecStreams connects the standard streams of the remote command. Stderr is merged into Stdout when a TTY
+// is allocated, Resize delivers the terminal size changes of the TTY.
+type ExecStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Resize <-chan TerminalSize
+}
+
+// terminalSizeQueue adapts the resize channel to the executor, ending when the channel is closed or the
+// context is canceled
+type terminalSizeQueue struct {
+	ctx    context.Context
+	resize <-chan TerminalSize
+}
+
+func (q *terminalSizeQueue) Next() *TerminalSize {
+	select {
+	case size, ok := <-q.resize:
+		if !ok {
+			return nil
+		}
+		return &size
+	case <-q.ctx.Done():
+		return nil
+	}
+}
+
+// podExecutor creates the executor of a command in a pod container, preferring the WebSocket protocol and
+// falling back to SPDY for API servers that do not support it
+func (kc *KubeConnection) podExecutor(namespace string, pod string, opts ExecOptions) (remotecommand.Executor, error) {
+	kc.UpdateLastUsed()
+
+	req := kc.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin,
+			Stdout:    true,
+			Stderr:    !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(kc.clientConfig, "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(kc.clientConfig, "GET", req.URL().String())
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, shouldFallbackToSPDY)
+}
+
+// shouldFallbackToSPDY reports whether a WebSocket upgrade failed in a way that SPDY may still succeed
+func shouldFallbackToSPDY(err error) bool {
+	return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+}
+
+// runExecutor streams the command until it exits or the context is canceled. A non-zero exit code of the
+// command is returned as such, not as an error.
+func runExecutor(ctx context.Context, executor remotecommand.Executor, opts ExecOptions, streams ExecStreams) (int, error) {
+	streamOpts := remotecommand.StreamOptions{
+		Stdout: streams.Stdout,
+		Tty:    opts.TTY,
+	}
+	if opts.Stdin {
+		streamOpts.Stdin = streams.Stdin
+	}
+	if !opts.TTY {
+		streamOpts.Stderr = streams.Stderr
+	}
+	if opts.TTY && streams.Resize != nil {
+		streamOpts.TerminalSizeQueue = &terminalSizeQueue{ctx: ctx, resize: streams.Resize}
+	}
+
+	err := executor.StreamWithContext(ctx, streamOpts)
+	if err != nil {
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.Exited() {
+			return exitErr.ExitStatus(), nil
+		}
+		return 0, err
+	}
+
+	return 0, nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rneacsu/spyglass/internal/logger"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -16,9 +17,11 @@ import (
 
 type ListWatcher struct {
 	*baseWatcher
-	client      *dynamic.DynamicClient
-	objListLock sync.RWMutex
-	objList     map[string]*unstructured.Unstructured
+	client          dynamic.Interface
+	objListLock     sync.RWMutex
+	objList         map[string]*unstructured.Unstructured
+	resourceVersion string
+	subscribers     *broadcaster[ResourceEvent]
 }
 
 func NewListWatcher(clientConfig *rest.Config, config WatcherConfig) (*ListWatcher, error) {
@@ -28,91 +31,203 @@ func NewListWatcher(clientConfig *rest.Config, config WatcherConfig) (*ListWatch
 		return nil, err
 	}
 
+	return newListWatcher(client, config), nil
+}
+
+func newListWatcher(client dynamic.Interface, config WatcherConfig) *ListWatcher {
 	return &ListWatcher{
 		baseWatcher: NewBaseWatcher(config, WatcherTypeList),
 		client:      client,
 		objList:     make(map[string]*unstructured.Unstructured),
-	}, nil
+		subscribers: newBroadcaster[ResourceEvent](),
+	}
+}
+
+// ResourceEvent is a change to a single object of a watched list
+type ResourceEvent struct {
+	Type   watch.EventType
+	Object *unstructured.Unstructured
 }
 
 func (lw *ListWatcher) List(ctx context.Context) ([]*unstructured.Unstructured, error) {
 	lw.watchLock.Lock()
 	defer lw.watchLock.Unlock()
 
-	if lw.watch == nil {
-		// Start by listing the resources
-		listOpt := metav1.ListOptions{}
+	if err := lw.ensureWatch(ctx); err != nil {
+		return nil, err
+	}
 
-		var listResult *unstructured.UnstructuredList
-		var err error
+	lw.objListLock.RLock()
+	defer lw.objListLock.RUnlock()
 
-		if lw.config.Namespace != "" {
-			listResult, err = lw.client.Resource(lw.config.GVR).Namespace(lw.config.Namespace).List(ctx, listOpt)
-		} else {
-			listResult, err = lw.client.Resource(lw.config.GVR).List(ctx, listOpt)
-		}
+	return lw.snapshot(), nil
+}
+
+// Watch returns the current list together with a subscription to every subsequent object change.
+// The subscription must be closed by the caller.
+func (lw *ListWatcher) Watch(ctx context.Context) (*unstructured.UnstructuredList, *Subscription[ResourceEvent], error) {
+	lw.watchLock.Lock()
+	defer lw.watchLock.Unlock()
+
+	if err := lw.ensureWatch(ctx); err != nil {
+		return nil, nil, err
+	}
 
+	// Take the snapshot and subscribe under the same lock so no event is missed in between
+	lw.objListLock.RLock()
+	defer lw.objListLock.RUnlock()
+
+	list := &unstructured.UnstructuredList{}
+	list.SetResourceVersion(lw.resourceVersion)
+	for _, obj := range lw.snapshot() {
+		list.Items = append(list.Items, *obj)
+	}
+
+	return list, newSubscription(lw.baseWatcher, lw.subscribers), nil
+}
+
+func (lw *ListWatcher) ensureWatch(ctx context.Context) error {
+	return lw.baseWatcher.ensureWatch(ctx, lw)
+}
+
+// ListPage fetches a single page of resources straight from the API server, bypassing the cache
+func (lw *ListWatcher) ListPage(ctx context.Context, limit int64, continueToken string) (*unstructured.UnstructuredList, error) {
+	return listPage(ctx, lw.client, lw.config, limit, continueToken)
+}
+
+// listPage fetches a single page of the resources selected by a watcher config
+func listPage(ctx context.Context, client dynamic.Interface, config WatcherConfig, limit int64, continueToken string) (*unstructured.UnstructuredList, error) {
+	listOpt := metav1.ListOptions{
+		LabelSelector: config.LabelSelector,
+		FieldSelector: config.FieldSelector,
+		Limit:         limit,
+		Continue:      continueToken,
+	}
+
+	listResult, err := resourceClient(client, config).List(ctx, listOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list (context: %s, resource: %s, namespace: %s): %w", config.KubeContext, config.GVR, config.Namespace, err)
+	}
+
+	return listResult, nil
+}
+
+func (lw *ListWatcher) list(ctx context.Context) (string, error) {
+	objList := make(map[string]*unstructured.Unstructured)
+	var resourceVersion string
+
+	// List in chunks to keep the size of each response bounded on large clusters
+	limit := int64(DefaultListPageSize)
+	continueToken := ""
+	for {
+		listResult, err := lw.ListPage(ctx, limit, continueToken)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list (context: %s, resource: %s): %w", lw.config.KubeContext, lw.config.GVR, err)
+			if continueToken != "" && isExpiredError(err) {
+				// The snapshot behind the continue token was compacted, fall back to a full list
+				objList = make(map[string]*unstructured.Unstructured)
+				limit = 0
+				continueToken = ""
+				continue
+			}
+			return "", err
 		}
 
-		// Then start a background watch
-		timeout := int64(DefaultWatchTimeout.Seconds())
-		watchOpts := metav1.ListOptions{
-			ResourceVersion: listResult.GetResourceVersion(),
-			TimeoutSeconds:  &timeout,
+		for idk := range listResult.Items {
+			obj := &listResult.Items[idk]
+			objList[string(obj.GetUID())] = obj
 		}
-		watcher, err := lw.client.Resource(lw.config.GVR).Watch(context.Background(), watchOpts)
-		if err != nil {
-			return nil, fmt.Errorf("failed to watch list (context: %s, resource: %s): %w", lw.config.KubeContext, lw.config.GVR, err)
+
+		// Every chunk is served from the snapshot of the first one
+		if continueToken == "" {
+			resourceVersion = listResult.GetResourceVersion()
 		}
 
-		lw.watch = watcher
+		continueToken = listResult.GetContinue()
+		if continueToken == "" {
+			break
+		}
+	}
 
-		// Add the initial list to the object list
-		lw.objListLock.Lock()
-		for idk := range listResult.Items {
-			obj := &listResult.Items[idk]
+	// Replace the whole cache so objects deleted while not watching are dropped,
+	// and resync subscribers under the same lock so none of them mixes both states
+	lw.objListLock.Lock()
+	defer lw.objListLock.Unlock()
+	lw.objList = objList
+	lw.resourceVersion = resourceVersion
+	lw.subscribers.closeAll()
+
+	return resourceVersion, nil
+}
+
+func (lw *ListWatcher) startWatch(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	timeout := int64(DefaultWatchTimeout.Seconds())
+	watchOpts := metav1.ListOptions{
+		LabelSelector:       lw.config.LabelSelector,
+		FieldSelector:       lw.config.FieldSelector,
+		ResourceVersion:     resourceVersion,
+		TimeoutSeconds:      &timeout,
+		AllowWatchBookmarks: true,
+	}
+	// Watch with the same scope as the list, a namespaced view must not watch the whole cluster
+	watcher, err := resourceClient(lw.client, lw.config).Watch(ctx, watchOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch list (context: %s, resource: %s, namespace: %s): %w", lw.config.KubeContext, lw.config.GVR, lw.config.Namespace, err)
+	}
+
+	return watcher, nil
+}
+
+func (lw *ListWatcher) handleEvent(event watch.Event) {
+	lw.objListLock.Lock()
+	defer lw.objListLock.Unlock()
+
+	switch event.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		obj := event.Object.(*unstructured.Unstructured)
+		lw.resourceVersion = obj.GetResourceVersion()
+		if event.Type == watch.Deleted {
+			if _, ok := lw.objList[string(obj.GetUID())]; !ok {
+				// Already removed when deleted through the connection
+				return
+			}
+			delete(lw.objList, string(obj.GetUID()))
+		} else {
 			lw.objList[string(obj.GetUID())] = obj
 		}
-		lw.objListLock.Unlock()
-
-		lw.watchWG.Add(1)
-		go func() {
-			defer lw.watchWG.Done()
-			logger.Infow("background watching started", lw.logContext...)
-
-			for event := range watcher.ResultChan() {
-				lw.objListLock.Lock()
-				switch event.Type {
-				case watch.Added, watch.Modified:
-					obj := event.Object.(*unstructured.Unstructured)
-					lw.objList[string(obj.GetUID())] = obj
-				case watch.Deleted:
-					obj := event.Object.(*unstructured.Unstructured)
-					delete(lw.objList, string(obj.GetUID()))
-				case watch.Error:
-					var logMsg string
-					if status, ok := event.Object.(*metav1.Status); ok {
-						logMsg = fmt.Sprintf("watch event error: %s, reason: %s", status.Message, status.Reason)
-					} else {
-						logMsg = fmt.Sprintf("watch event error: %v", event.Object)
-					}
-					logger.Errorw(logMsg, lw.logContext...)
-				}
-				lw.objListLock.Unlock()
-			}
 
-			lw.watchLock.Lock()
-			defer lw.watchLock.Unlock()
-			lw.watch = nil
+		lw.subscribers.publish(ResourceEvent{
+			Type:   event.Type,
+			Object: obj,
+		})
+	case watch.Bookmark:
+		lw.resourceVersion = objectResourceVersion(event.Object)
+	case watch.Error:
+		logger.Errorw(watchErrorMessage(event), lw.logContext...)
+	}
+}
+
+func (lw *ListWatcher) objectDeleted(uid types.UID) {
+	lw.objListLock.Lock()
+	defer lw.objListLock.Unlock()
 
-			logger.Infow("background watching finished", lw.logContext...)
-		}()
+	obj, ok := lw.objList[string(uid)]
+	if !ok {
+		return
 	}
+	delete(lw.objList, string(uid))
 
-	lw.objListLock.RLock()
-	defer lw.objListLock.RUnlock()
+	lw.subscribers.publish(ResourceEvent{
+		Type:   watch.Deleted,
+		Object: obj,
+	})
+}
+
+func (lw *ListWatcher) resync() {
+	lw.subscribers.closeAll()
+}
+
+// snapshot returns the current objects sorted by name. Must be called with objListLock held.
+func (lw *ListWatcher) snapshot() []*unstructured.Unstructured {
 	resourceList := make([]*unstructured.Unstructured, 0, len(lw.objList))
 	for _, obj := range lw.objList {
 		resourceList = append(resourceList, obj)
@@ -123,5 +238,5 @@ func (lw *ListWatcher) List(ctx context.Context) ([]*unstructured.Unstructured,
 		return resourceList[i].GetName() < resourceList[j].GetName()
 	})
 
-	return resourceList, nil
+	return resourceList
 }
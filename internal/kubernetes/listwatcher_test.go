@@ -0,0 +1,210 @@
+package kubernetes
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitGlobalLogger(false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestPod(name string) *unstructured.Unstructured {
+	pod := &unstructured.Unstructured{}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace("default")
+	pod.SetName(name)
+	pod.SetUID(types.UID("uid-" + name))
+	return pod
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{
+		podsGVR: "PodList",
+		crdsGVR: "CustomResourceDefinitionList",
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// controlledWatches hands out fake watches the test ends at will. The watch following an ended one fails
+// with an expired resource version, forcing the watcher to relist.
+type controlledWatches struct {
+	lock    sync.Mutex
+	watches []*watch.FakeWatcher
+	ended   bool
+	started chan struct{}
+}
+
+func newControlledWatches(client *dynamicfake.FakeDynamicClient) *controlledWatches {
+	cw := &controlledWatches{started: make(chan struct{}, 10)}
+	client.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		cw.lock.Lock()
+		defer cw.lock.Unlock()
+
+		if cw.ended {
+			cw.ended = false
+			return true, nil, apierrors.NewResourceExpired("too old resource version")
+		}
+
+		w := watch.NewFake()
+		cw.watches = append(cw.watches, w)
+		cw.started <- struct{}{}
+		return true, w, nil
+	})
+	return cw
+}
+
+// end stops the current watch, the objects deleted meanwhile never being announced
+func (cw *controlledWatches) end() {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	cw.ended = true
+	cw.watches[len(cw.watches)-1].Stop()
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// waitClosed waits for a subscription to be closed, failing if it receives an event instead
+func waitClosed[T any](t *testing.T, sub *Subscription[T]) {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if ok {
+			t.Fatalf("unexpected event %+v, expected the subscription to be closed", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the subscription to be closed")
+	}
+}
+
+func TestListWatcherRelistDropsObjectsDeletedWhileNotWatching(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newTestPod("a"), newTestPod("b"))
+	watches := newControlledWatches(client)
+
+	lw := newListWatcher(client, WatcherConfig{KubeContext: "test", GVR: podsGVR, Namespace: "default"})
+	defer lw.Stop()
+
+	list, sub, err := lw.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer sub.Close()
+	waitFor(t, watches.started, "the first watch")
+	if len(list.Items) != 2 {
+		t.Fatalf("Watch() listed %d objects, want 2", len(list.Items))
+	}
+
+	if err := client.Resource(podsGVR).Namespace("default").Delete(ctx, "b", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	watches.end()
+
+	// The relist resyncs the subscriber before the watch is resumed
+	waitClosed(t, sub)
+	waitFor(t, watches.started, "the watch to be resumed")
+
+	objects, err := lw.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(objects) != 1 || objects[0].GetName() != "a" {
+		t.Fatalf("List() = %v, want only a", objectNames(objects))
+	}
+
+	list, resub, err := lw.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer resub.Close()
+	if len(list.Items) != 1 || list.Items[0].GetName() != "a" {
+		t.Fatalf("Watch() listed %d objects, want only a", len(list.Items))
+	}
+}
+
+func TestTableWatcherRelistDropsRowsDeletedWhileNotWatching(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newTestPod("a"), newTestPod("b"))
+	watches := newControlledWatches(client)
+
+	printer, err := newTablePrinter(defaultPrinterColumns)
+	if err != nil {
+		t.Fatalf("newTablePrinter() error = %v", err)
+	}
+
+	// Build the rows locally so the table is served by the fake dynamic client
+	config := WatcherConfig{KubeContext: "test", GVR: podsGVR, Namespace: "default"}
+	printers := newPrinterCache()
+	printers.set(config.GVR, printer)
+	tw := newTableWatcher(&tableLister{dynamic: client, config: config, printers: printers}, config, func() *columnEvaluator { return nil })
+	defer tw.Stop()
+
+	table, sub, err := tw.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer sub.Close()
+	waitFor(t, watches.started, "the first watch")
+	if len(table.Rows) != 2 {
+		t.Fatalf("Watch() listed %d rows, want 2", len(table.Rows))
+	}
+
+	if err := client.Resource(podsGVR).Namespace("default").Delete(ctx, "b", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	watches.end()
+
+	waitClosed(t, sub)
+	waitFor(t, watches.started, "the watch to be resumed")
+
+	table, err = tw.GetTable(ctx)
+	if err != nil {
+		t.Fatalf("GetTable() error = %v", err)
+	}
+	if len(table.Rows) != 1 || table.Rows[0].Cells[0] != "a" {
+		t.Fatalf("GetTable() rows = %v, want only a", table.Rows)
+	}
+
+	table, resub, err := tw.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer resub.Close()
+	if len(table.Rows) != 1 || table.Rows[0].Cells[0] != "a" {
+		t.Fatalf("Watch() rows = %v, want only a", table.Rows)
+	}
+}
+
+func objectNames(objects []*unstructured.Unstructured) []string {
+	names := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		names = append(names, obj.GetName())
+	}
+	return names
+}
@@ -0,0 +1,68 @@
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogOptions selects the container and the range of logs to stream
+type LogOptions struct {
+	Container  string
+	Follow     bool
+	TailLines  *int64
+	SinceTime  *time.Time
+	Timestamps bool
+	Previous   bool
+}
+
+// StreamPodLogs opens the log stream of a pod container. The stream ends when the context is canceled.
+func (kc *KubeConnection) StreamPodLogs(ctx context.Context, namespace string, pod string, opts LogOptions) (io.ReadCloser, error) {
+	kc.UpdateLastUsed()
+
+	logOpts := &corev1.PodLogOptions{
+		Container:  opts.Container,
+		Follow:     opts.Follow,
+		TailLines:  opts.TailLines,
+		Timestamps: opts.Timestamps,
+		Previous:   opts.Previous,
+	}
+	if opts.SinceTime != nil {
+		sinceTime := metav1.NewTime(*opts.SinceTime)
+		logOpts.SinceTime = &sinceTime
+	}
+
+	stream, err := kc.clientset.CoreV1().Pods(namespace).GetLogs(pod, logOpts).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream logs (context: %s, namespace: %s, pod: %s, container: %s): %w", kc.kubeContext, namespace, pod, opts.Container, err)
+	}
+
+	return stream, nil
+}
+
+// readLogLines calls handle for every line of the stream, without the trailing newline, until the stream
+// ends, the context is canceled or handle fails
+func readLogLines(ctx context.Context, stream io.Reader, handle func(line string) error) error {
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if handleErr := handle(strings.TrimSuffix(line, "\n")); handleErr != nil {
+				return handleErr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
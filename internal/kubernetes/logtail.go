@@ -0,0 +1,240 @@
+package kubernetes
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// LogLine is a single log line of a pod container
+type LogLine struct {
+	Namespace string
+	Pod       string
+	Container string
+	Timestamp time.Time
+	Line      string
+}
+
+// TailOptions selects the pods and containers whose logs are tailed
+type TailOptions struct {
+	// Container filters the containers by name, all containers are tailed when nil
+	Container *regexp.Regexp
+	TailLines *int64
+	SinceTime *time.Time
+}
+
+// tailKey identifies a container of a pod. Pods are told apart by UID, so a pod recreated under the
+// same name is tailed from the start.
+type tailKey struct {
+	pod       types.UID
+	container string
+}
+
+// containerTail is a running log stream of a single container instance
+type containerTail struct {
+	key    tailKey
+	cancel context.CancelFunc
+}
+
+// podLogTailer follows the logs of every container of a changing set of pods
+type podLogTailer struct {
+	conn        *KubeConnection
+	opts        TailOptions
+	lines       chan LogLine
+	ended       chan *containerTail
+	tails       map[tailKey]*containerTail
+	attachedIDs map[tailKey]string
+	wg          sync.WaitGroup
+}
+
+func newPodLogTailer(conn *KubeConnection, opts TailOptions) *podLogTailer {
+	return &podLogTailer{
+		conn:        conn,
+		opts:        opts,
+		lines:       make(chan LogLine, DefaultSubscriberBuffer),
+		ended:       make(chan *containerTail),
+		tails:       make(map[tailKey]*containerTail),
+		attachedIDs: make(map[tailKey]string),
+	}
+}
+
+func (t *podLogTailer) run(ctx context.Context, events <-chan ResourceEvent, handle func(line LogLine) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				t.attach(ctx, event.Object)
+			case watch.Deleted:
+				t.detach(event.Object.GetUID())
+			}
+		case line := <-t.lines:
+			if err := handle(line); err != nil {
+				return err
+			}
+		case tail := <-t.ended:
+			if t.tails[tail.key] == tail {
+				delete(t.tails, tail.key)
+			}
+		}
+	}
+}
+
+// reconcile attaches to all pods of the snapshot and detaches from pods no longer in it
+func (t *podLogTailer) reconcile(ctx context.Context, pods []unstructured.Unstructured) {
+	current := make(map[types.UID]bool, len(pods))
+	for i := range pods {
+		current[pods[i].GetUID()] = true
+		t.attach(ctx, &pods[i])
+	}
+
+	// Every tailed container has an attached instance, so this covers all tails
+	for key := range t.attachedIDs {
+		if !current[key.pod] {
+			t.detach(key.pod)
+		}
+	}
+}
+
+// attach starts tailing every container instance of the pod that has started and is not tailed yet.
+// Init containers are tailed too, each one once it starts. Ephemeral debug containers are left out.
+func (t *podLogTailer) attach(ctx context.Context, obj *unstructured.Unstructured) {
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pod); err != nil {
+		logger.Warnw("failed to decode pod", "pod", obj.GetName(), "namespace", obj.GetNamespace(), "error", err)
+		return
+	}
+
+	statuses := append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if t.opts.Container != nil && !t.opts.Container.MatchString(status.Name) {
+			continue
+		}
+		if status.ContainerID == "" || (status.State.Running == nil && status.State.Terminated == nil) {
+			continue
+		}
+
+		key := tailKey{pod: pod.UID, container: status.Name}
+		if _, ok := t.tails[key]; ok {
+			continue
+		}
+
+		previousID, seen := t.attachedIDs[key]
+		if previousID == status.ContainerID {
+			// This container instance was already tailed to its end
+			continue
+		}
+		t.attachedIDs[key] = status.ContainerID
+
+		opts := LogOptions{
+			Container:  status.Name,
+			Follow:     true,
+			Timestamps: true,
+		}
+		if !seen {
+			// Only the first instance of a container honors the requested range, restarted ones are tailed from the start
+			opts.TailLines = t.opts.TailLines
+			opts.SinceTime = t.opts.SinceTime
+		}
+
+		tailCtx, cancel := context.WithCancel(ctx)
+		tail := &containerTail{
+			key:    key,
+			cancel: cancel,
+		}
+		t.tails[key] = tail
+
+		t.wg.Add(1)
+		go t.follow(tailCtx, tail, pod.Namespace, pod.Name, opts)
+	}
+}
+
+// detach stops tailing every container of the pod
+func (t *podLogTailer) detach(uid types.UID) {
+	for key, tail := range t.tails {
+		if key.pod == uid {
+			tail.cancel()
+			delete(t.tails, key)
+		}
+	}
+	for key := range t.attachedIDs {
+		if key.pod == uid {
+			delete(t.attachedIDs, key)
+		}
+	}
+}
+
+func (t *podLogTailer) follow(ctx context.Context, tail *containerTail, namespace string, pod string, opts LogOptions) {
+	defer t.wg.Done()
+	defer func() {
+		select {
+		case t.ended <- tail:
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := t.conn.StreamPodLogs(ctx, namespace, pod, opts)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warnw("failed to tail container logs", "namespace", namespace, "pod", pod, "container", opts.Container, "error", err)
+		}
+		return
+	}
+	defer stream.Close()
+
+	err = readLogLines(ctx, stream, func(line string) error {
+		logLine := LogLine{
+			Namespace: namespace,
+			Pod:       pod,
+			Container: opts.Container,
+		}
+		logLine.Timestamp, logLine.Line = splitLogTimestamp(line)
+
+		select {
+		case t.lines <- logLine:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		logger.Warnw("failed to read container logs", "namespace", namespace, "pod", pod, "container", opts.Container, "error", err)
+	}
+}
+
+// stop detaches from all containers and waits for their streams to end
+func (t *podLogTailer) stop() {
+	for key, tail := range t.tails {
+		tail.cancel()
+		delete(t.tails, key)
+	}
+	t.wg.Wait()
+}
+
+// splitLogTimestamp splits the timestamp the API server prepends to each line when requested
+func splitLogTimestamp(line string) (time.Time, string) {
+	if idx := strings.IndexByte(line, ' '); idx > 0 {
+		if ts, err := time.Parse(time.RFC3339Nano, line[:idx]); err == nil {
+			return ts, line[idx+1:]
+		}
+	}
+	return time.Now(), line
+}
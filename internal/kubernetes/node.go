@@ -0,0 +1,288 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DefaultEvictionRetryDelay is the delay before evicting a pod again when a disruption budget refused it
+	DefaultEvictionRetryDelay = 5 * time.Second
+
+	// DefaultDeletionPollInterval is the interval at which evicted pods are checked for deletion
+	DefaultDeletionPollInterval = time.Second
+
+	// DefaultDrainConcurrency is the maximum number of pods evicted at once by a drain
+	DefaultDrainConcurrency = 10
+
+	// mirrorPodAnnotation marks the API server copies of static pods, which cannot be evicted
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+var nodesGVR = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+// DrainEventType is the progress of the eviction of a pod
+type DrainEventType string
+
+const (
+	// DrainEventSkipped is sent for DaemonSet and mirror pods, left on the node
+	DrainEventSkipped DrainEventType = "skipped"
+	// DrainEventEvicting is sent when the pod starts being evicted, with a warning as message if any
+	DrainEventEvicting DrainEventType = "evicting"
+	// DrainEventRetrying is sent when a disruption budget refused the eviction, which is retried
+	DrainEventRetrying DrainEventType = "retrying"
+	// DrainEventEvicted is sent once the eviction was accepted, the pod then terminates
+	DrainEventEvicted DrainEventType = "evicted"
+	// DrainEventDeleted is sent once the pod is gone
+	DrainEventDeleted DrainEventType = "deleted"
+	// DrainEventFailed is sent when the pod could not be evicted, or was left on the node as not managed by
+	// an existing controller without force
+	DrainEventFailed DrainEventType = "failed"
+)
+
+// DrainEvent reports the progress of the eviction of a single pod
+type DrainEvent struct {
+	Namespace string
+	Pod       string
+	Type      DrainEventType
+	Message   string
+}
+
+// DrainOptions controls the eviction of the pods of a node
+type DrainOptions struct {
+	// GracePeriodSeconds overrides the termination grace period of the pods when set
+	GracePeriodSeconds *int64
+	// Timeout bounds the whole drain, no timeout when zero
+	Timeout time.Duration
+	// Force evicts the pods not managed by a controller, or by a DaemonSet that no longer exists, which are not
+	// recreated elsewhere. Without it they are left on the node and the drain fails, as with kubectl.
+	Force bool
+}
+
+// CordonNode marks a node as unschedulable, or schedulable again
+func (kc *KubeConnection) CordonNode(ctx context.Context, name string, unschedulable bool) (*unstructured.Unstructured, error) {
+	kc.UpdateLastUsed()
+
+	// Uncordoning removes the field, as kubectl does
+	var value any
+	if unschedulable {
+		value = true
+	}
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"unschedulable": value,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := kc.dynamic.Resource(nodesGVR).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cordon node (context: %s, node: %s): %w", kc.kubeContext, name, err)
+	}
+
+	return obj, nil
+}
+
+// DrainNode cordons a node and evicts its pods through the Eviction API, so disruption budgets are honored.
+// DaemonSet and mirror pods are skipped, pods not managed by a controller fail the drain unless forced or
+// finished, as do pods of a DaemonSet that no longer exists. handle
+// is called for every step of every pod, never concurrently. The drain ends once all pods are gone, or fails
+// when the timeout expires.
+func (kc *KubeConnection) DrainNode(ctx context.Context, name string, opts DrainOptions, handle func(event DrainEvent) error) error {
+	kc.UpdateLastUsed()
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if _, err := kc.CordonNode(ctx, name, true); err != nil {
+		return err
+	}
+
+	pods, err := kc.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods (context: %s, node: %s): %w", kc.kubeContext, name, err)
+	}
+
+	// Stop evicting as soon as the events cannot be delivered anymore
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handleLock sync.Mutex
+	var handleErr error
+	send := func(pod *corev1.Pod, eventType DrainEventType, message string) {
+		handleLock.Lock()
+		defer handleLock.Unlock()
+
+		if handleErr != nil {
+			return
+		}
+		handleErr = handle(DrainEvent{
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			Type:      eventType,
+			Message:   message,
+		})
+		if handleErr != nil {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	var failedPods atomic.Int32
+	workers := make(chan struct{}, DefaultDrainConcurrency)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		reason, warning, err := kc.drainSkipReason(ctx, pod, opts.Force)
+		if err != nil {
+			failedPods.Add(1)
+			send(pod, DrainEventFailed, err.Error())
+			continue
+		}
+		if reason != "" {
+			send(pod, DrainEventSkipped, reason)
+			continue
+		}
+		// Finished pods are not running anything, evicting them loses nothing
+		if !opts.Force && metav1.GetControllerOf(pod) == nil && !podFinished(pod) {
+			failedPods.Add(1)
+			send(pod, DrainEventFailed, "not managed by a controller, the pod would not be recreated: drain with force to evict it")
+			continue
+		}
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if err := kc.evictPod(ctx, pod, opts, warning, send); err != nil {
+				failedPods.Add(1)
+				send(pod, DrainEventFailed, err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if handleErr != nil {
+		return handleErr
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to drain node (context: %s, node: %s): %w", kc.kubeContext, name, err)
+	}
+	if failed := failedPods.Load(); failed > 0 {
+		return fmt.Errorf("failed to drain node (context: %s, node: %s): %d pods could not be evicted", kc.kubeContext, name, failed)
+	}
+
+	return nil
+}
+
+// drainSkipReason returns why a pod is left on a drained node, or an empty string if it must be evicted.
+// As with kubectl, a pod of a DaemonSet that no longer exists fails the drain unless forced, in which case
+// it is evicted with a warning as it will not be recreated.
+func (kc *KubeConnection) drainSkipReason(ctx context.Context, pod *corev1.Pod, force bool) (reason string, warning string, err error) {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return "mirror pod of a static pod", "", nil
+	}
+
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil || controller.Kind != "DaemonSet" {
+		return "", "", nil
+	}
+
+	_, err = kc.clientset.AppsV1().DaemonSets(pod.Namespace).Get(ctx, controller.Name, metav1.GetOptions{})
+	if err == nil {
+		return "managed by DaemonSet " + controller.Name, "", nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("failed to get DaemonSet %s: %w", controller.Name, err)
+	}
+	if !force {
+		return "", "", fmt.Errorf("managed by DaemonSet %s which no longer exists, the pod would not be recreated: drain with force to evict it", controller.Name)
+	}
+	return "", fmt.Sprintf("managed by DaemonSet %s which no longer exists, the pod will not be recreated", controller.Name), nil
+}
+
+// podFinished reports whether all containers of a pod have terminated for good
+func podFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// evictPod evicts a pod, retrying while a disruption budget refuses it, and waits for the pod to be gone.
+// The warning, if any, is reported along with the start of the eviction.
+func (kc *KubeConnection) evictPod(ctx context.Context, pod *corev1.Pod, opts DrainOptions, warning string, send func(pod *corev1.Pod, eventType DrainEventType, message string)) error {
+	send(pod, DrainEventEvicting, warning)
+
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{
+			GracePeriodSeconds: opts.GracePeriodSeconds,
+			Preconditions:      &metav1.Preconditions{UID: &pod.UID},
+		},
+	}
+
+	for {
+		err := kc.clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err == nil {
+			break
+		}
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			// Already gone, or replaced by another pod of the same name
+			send(pod, DrainEventDeleted, "")
+			return nil
+		}
+		if !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+
+		send(pod, DrainEventRetrying, err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(DefaultEvictionRetryDelay):
+		}
+	}
+
+	send(pod, DrainEventEvicted, "")
+
+	for {
+		current, err := kc.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+			send(pod, DrainEventDeleted, "")
+			return nil
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(DefaultDeletionPollInterval):
+		}
+	}
+}
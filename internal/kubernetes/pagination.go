@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// pageCursor is the position of the next page across the namespaces of a query
+type pageCursor struct {
+	// Namespace is the index of the namespace the next page starts from
+	Namespace int `json:"n"`
+	// Continue is the API server continue token within that namespace
+	Continue string `json:"c,omitempty"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string, namespaces int) (pageCursor, error) {
+	cursor := pageCursor{}
+	if token == "" {
+		return cursor, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.Namespace < 0 || cursor.Namespace >= namespaces {
+		return cursor, apierrors.NewBadRequest("invalid continue token")
+	}
+
+	return cursor, nil
+}
+
+// pageFetcher fetches up to limit items of a namespace, starting at the continue token.
+// It returns the continue token of the API server and the number of items fetched.
+type pageFetcher func(config WatcherConfig, limit int64, continueToken string) (string, int, error)
+
+// paginate fills a page of up to pageSize items from the namespaces in order, moving on to the next
+// namespace when one is exhausted, and returns the token of the next page or empty on the last page
+func paginate(configs []WatcherConfig, pageSize int64, token string, fetch pageFetcher) (string, error) {
+	cursor, err := decodeCursor(token, len(configs))
+	if err != nil {
+		return "", err
+	}
+
+	remaining := pageSize
+	for idx := cursor.Namespace; idx < len(configs); idx++ {
+		continueToken, count, err := fetch(configs[idx], remaining, cursor.Continue)
+		if err != nil {
+			return "", err
+		}
+		cursor.Continue = ""
+		remaining -= int64(count)
+
+		if continueToken != "" {
+			return encodeCursor(pageCursor{Namespace: idx, Continue: continueToken}), nil
+		}
+		if remaining <= 0 && idx+1 < len(configs) {
+			return encodeCursor(pageCursor{Namespace: idx + 1}), nil
+		}
+	}
+
+	return "", nil
+}
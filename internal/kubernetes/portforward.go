@@ -0,0 +1,400 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	// DefaultPortForwardAddress is the local address port forwards listen on when none is given
+	DefaultPortForwardAddress = "localhost"
+
+	// DefaultPortForwardRetryDelay is the delay before forwarding again after losing the connection to the pod
+	DefaultPortForwardRetryDelay = 2 * time.Second
+)
+
+// PortForwardKind is the kind of resource a port forward targets
+type PortForwardKind string
+
+const (
+	PortForwardKindPod     PortForwardKind = "pod"
+	PortForwardKindService PortForwardKind = "service"
+)
+
+// nextPortForwardID numbers the port forwards of all connections
+var nextPortForwardID atomic.Uint64
+
+// PortForwardOptions selects the target of a port forward and the local address to listen on. The remote
+// port of a service is the service port, forwarded to the target port of one of its ready pods. A zero
+// local port picks a free port.
+type PortForwardOptions struct {
+	Namespace    string
+	Kind         PortForwardKind
+	Name         string
+	RemotePort   int32
+	LocalAddress string
+	LocalPort    uint16
+}
+
+// PortForwardStatus describes an active port forward and the pod it currently forwards to
+type PortForwardStatus struct {
+	PortForwardOptions
+
+	ID          string
+	KubeContext string
+	Pod         string
+	PodPort     int32
+	Started     time.Time
+	// Error is the last error forwarding to the target, cleared once forwarding again
+	Error string
+}
+
+// portForward keeps a local port forwarded to its target, resolving the target pod again whenever the
+// connection to the pod is lost
+type portForward struct {
+	conn *KubeConnection
+	ctx  context.Context
+	stop context.CancelFunc
+	done chan struct{}
+
+	statusLock sync.Mutex
+	status     PortForwardStatus
+}
+
+// StartPortForward starts forwarding a local port to a pod or service, returning once the local port is
+// listening. The forward runs until stopped or until the connection is stopped, keeping the connection in
+// use meanwhile so it is not evicted. Must be called while the connection is acquired.
+func (kc *KubeConnection) StartPortForward(opts PortForwardOptions) (PortForwardStatus, error) {
+	kc.UpdateLastUsed()
+
+	if opts.LocalAddress == "" {
+		opts.LocalAddress = DefaultPortForwardAddress
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pf := &portForward{
+		conn: kc,
+		ctx:  ctx,
+		stop: cancel,
+		done: make(chan struct{}),
+		status: PortForwardStatus{
+			PortForwardOptions: opts,
+			ID:                 strconv.FormatUint(nextPortForwardID.Add(1), 10),
+			KubeContext:        kc.kubeContext,
+			Started:            time.Now(),
+		},
+	}
+
+	result, err := pf.forward()
+	if err != nil {
+		cancel()
+		return PortForwardStatus{}, fmt.Errorf("failed to forward port (context: %s, namespace: %s, %s: %s, port: %d): %w", kc.kubeContext, opts.Namespace, opts.Kind, opts.Name, opts.RemotePort, err)
+	}
+
+	kc.acquire()
+	kc.portForwardsLock.Lock()
+	kc.portForwards[pf.status.ID] = pf
+	kc.portForwardsLock.Unlock()
+
+	go pf.run(result)
+
+	return pf.getStatus(), nil
+}
+
+// PortForwards returns the status of the active port forwards, ordered by start
+func (kc *KubeConnection) PortForwards() []PortForwardStatus {
+	kc.portForwardsLock.Lock()
+	defer kc.portForwardsLock.Unlock()
+
+	statuses := make([]PortForwardStatus, 0, len(kc.portForwards))
+	for _, pf := range kc.portForwards {
+		statuses = append(statuses, pf.getStatus())
+	}
+	sortPortForwards(statuses)
+
+	return statuses
+}
+
+// StopPortForward stops a port forward, reporting whether it belonged to the connection
+func (kc *KubeConnection) StopPortForward(id string) bool {
+	kc.portForwardsLock.Lock()
+	pf, ok := kc.portForwards[id]
+	delete(kc.portForwards, id)
+	kc.portForwardsLock.Unlock()
+
+	if ok {
+		pf.Stop()
+	}
+
+	return ok
+}
+
+func (kc *KubeConnection) stopPortForwards() {
+	kc.portForwardsLock.Lock()
+	portForwards := kc.portForwards
+	kc.portForwards = make(map[string]*portForward)
+	kc.portForwardsLock.Unlock()
+
+	for _, pf := range portForwards {
+		pf.Stop()
+	}
+}
+
+// Stop closes the local listener and waits for the forward to end
+func (pf *portForward) Stop() {
+	pf.stop()
+	<-pf.done
+}
+
+func (pf *portForward) getStatus() PortForwardStatus {
+	pf.statusLock.Lock()
+	defer pf.statusLock.Unlock()
+
+	return pf.status
+}
+
+func (pf *portForward) setError(err error) {
+	pf.statusLock.Lock()
+	defer pf.statusLock.Unlock()
+
+	pf.status.Error = ""
+	if err != nil {
+		pf.status.Error = err.Error()
+	}
+}
+
+// run forwards again after each loss of the connection to the pod, until the forward is stopped. The
+// connection is released once the forward ends.
+func (pf *portForward) run(result <-chan error) {
+	defer close(pf.done)
+	defer pf.conn.release()
+
+	status := pf.getStatus()
+	logContext := []any{"context", status.KubeContext, "namespace", status.Namespace, string(status.Kind), status.Name, "port", status.RemotePort}
+
+	for {
+		if result != nil {
+			err := <-result
+			if pf.ctx.Err() != nil {
+				return
+			}
+			pf.setError(err)
+			logger.Infow("port forward lost connection, forwarding again", append(logContext, "error", err)...)
+		}
+
+		select {
+		case <-pf.ctx.Done():
+			return
+		case <-time.After(DefaultPortForwardRetryDelay):
+		}
+
+		var err error
+		result, err = pf.forward()
+		if err != nil {
+			pf.setError(err)
+			logger.Warnw("failed to forward port", append(logContext, "error", err)...)
+		}
+	}
+}
+
+// forward resolves the target pod and forwards the local port to it, returning once the local port is
+// listening. The returned channel receives the outcome of the forward once it ends.
+func (pf *portForward) forward() (<-chan error, error) {
+	status := pf.getStatus()
+
+	pod, podPort, err := pf.conn.resolvePortForwardTarget(pf.ctx, status.PortForwardOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := pf.conn.portForwardDialer(status.Namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan struct{})
+	ports := []string{fmt.Sprintf("%d:%d", status.LocalPort, podPort)}
+	errOut := &portForwardLogWriter{id: status.ID}
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{status.LocalAddress}, ports, pf.ctx.Done(), ready, io.Discard, errOut)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-result:
+		return nil, err
+	}
+
+	forwardedPorts, err := forwarder.GetPorts()
+	if err != nil {
+		forwarder.Close()
+		return nil, err
+	}
+
+	pf.statusLock.Lock()
+	// Keep listening on the same port when forwarding again
+	pf.status.LocalPort = forwardedPorts[0].Local
+	pf.status.Pod = pod
+	pf.status.PodPort = podPort
+	pf.status.Error = ""
+	pf.statusLock.Unlock()
+
+	return result, nil
+}
+
+// portForwardDialer creates the dialer of the port forward stream of a pod, preferring the WebSocket
+// protocol and falling back to SPDY for API servers that do not support it
+func (kc *KubeConnection) portForwardDialer(namespace string, pod string) (httpstream.Dialer, error) {
+	req := kc.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+
+	transport, upgrader, err := spdy.RoundTripperFor(kc.clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	spdyDialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+
+	websocketDialer, err := portforward.NewSPDYOverWebsocketDialer(req.URL(), kc.clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return portforward.NewFallbackDialer(websocketDialer, spdyDialer, shouldFallbackToSPDY), nil
+}
+
+// resolvePortForwardTarget returns the pod and pod port to forward to. Services resolve to the first ready
+// pod matching their selector.
+func (kc *KubeConnection) resolvePortForwardTarget(ctx context.Context, opts PortForwardOptions) (string, int32, error) {
+	switch opts.Kind {
+	case PortForwardKindPod:
+		pod, err := kc.clientset.CoreV1().Pods(opts.Namespace).Get(ctx, opts.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			return "", 0, apierrors.NewServiceUnavailable(fmt.Sprintf("pod %s is not running", pod.Name))
+		}
+		return pod.Name, opts.RemotePort, nil
+
+	case PortForwardKindService:
+		service, err := kc.clientset.CoreV1().Services(opts.Namespace).Get(ctx, opts.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		if len(service.Spec.Selector) == 0 {
+			return "", 0, apierrors.NewBadRequest(fmt.Sprintf("service %s has no pod selector", service.Name))
+		}
+
+		var servicePort *corev1.ServicePort
+		for i := range service.Spec.Ports {
+			if service.Spec.Ports[i].Port == opts.RemotePort {
+				servicePort = &service.Spec.Ports[i]
+				break
+			}
+		}
+		if servicePort == nil {
+			return "", 0, apierrors.NewBadRequest(fmt.Sprintf("service %s has no port %d", service.Name, opts.RemotePort))
+		}
+
+		pods, err := kc.clientset.CoreV1().Pods(opts.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+		})
+		if err != nil {
+			return "", 0, err
+		}
+
+		for _, pod := range pods.Items {
+			if pod.DeletionTimestamp != nil || !isPodReady(&pod) {
+				continue
+			}
+			if podPort, ok := resolveTargetPort(&pod, servicePort); ok {
+				return pod.Name, podPort, nil
+			}
+		}
+
+		return "", 0, apierrors.NewServiceUnavailable(fmt.Sprintf("service %s has no ready pods", service.Name))
+	}
+
+	return "", 0, apierrors.NewBadRequest(fmt.Sprintf("unsupported port forward target: %s", opts.Kind))
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// resolveTargetPort maps a service port to the port of the pod, looking up named target ports in the
+// container ports of the pod
+func resolveTargetPort(pod *corev1.Pod, servicePort *corev1.ServicePort) (int32, bool) {
+	switch {
+	case servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal == 0:
+		return servicePort.Port, true
+	case servicePort.TargetPort.Type == intstr.Int:
+		return servicePort.TargetPort.IntVal, true
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == servicePort.TargetPort.StrVal && port.Protocol == servicePort.Protocol {
+				return port.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func sortPortForwards(statuses []PortForwardStatus) {
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Started.Before(statuses[j].Started)
+	})
+}
+
+// portForwardLogWriter logs the errors the forwarder reports for individual local connections
+type portForwardLogWriter struct {
+	id string
+}
+
+func (w *portForwardLogWriter) Write(p []byte) (int, error) {
+	logger.Warnw("port forward error", "id", w.id, "error", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// portForwardNotFound is the error returned when stopping an unknown port forward
+func portForwardNotFound(id string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "portforwards"}, id)
+}
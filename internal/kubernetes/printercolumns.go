@@ -0,0 +1,283 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metatable "k8s.io/apimachinery/pkg/api/meta/table"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+var (
+	crdsGVR = crdsGroupResource.WithVersion("v1")
+
+	// defaultPrinterColumns are the columns of custom resources without additionalPrinterColumns, as
+	// defaulted by the API server
+	defaultPrinterColumns = []printerColumn{
+		{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+	}
+
+	// minimalTableColumns are the columns of the tables of resources without printer columns
+	minimalTableColumns = map[string]bool{
+		"Name":       true,
+		"Age":        true,
+		"Created At": true,
+	}
+)
+
+// printerColumn is an additional printer column of a custom resource definition version
+type printerColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	Priority    int32  `json:"priority,omitempty"`
+	JSONPath    string `json:"jsonPath"`
+}
+
+// tablePrinter builds tables from full objects, evaluating printer columns locally as the API server does
+// for custom resources
+type tablePrinter struct {
+	columns []metav1.TableColumnDefinition
+	// pathsLock serializes the evaluations, JSONPaths keeping state while evaluated
+	pathsLock sync.Mutex
+	paths     []*jsonpath.JSONPath
+}
+
+// printerCache holds the printer resolved for each resource of a connection, shared by its table watchers
+// and page requests. A nil printer means the table of the server is used.
+type printerCache struct {
+	lock     sync.Mutex
+	printers map[schema.GroupVersionResource]*tablePrinter
+}
+
+func newPrinterCache() *printerCache {
+	return &printerCache{printers: make(map[schema.GroupVersionResource]*tablePrinter)}
+}
+
+func (pc *printerCache) get(gvr schema.GroupVersionResource) (*tablePrinter, bool) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	printer, ok := pc.printers[gvr]
+	return printer, ok
+}
+
+func (pc *printerCache) set(gvr schema.GroupVersionResource, printer *tablePrinter) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pc.printers[gvr] = printer
+}
+
+// reset drops all resolved printers, so they are resolved again from the current definitions
+func (pc *printerCache) reset() {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	clear(pc.printers)
+}
+
+// crdPrinterColumns returns the additional printer columns of the custom resource definition of a
+// resource version. It returns no columns if the resource is not defined by a custom resource definition
+// or the definition cannot be read.
+func crdPrinterColumns(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource) ([]printerColumn, error) {
+	crd, err := client.Resource(crdsGVR).Get(ctx, gvr.GroupResource().String(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		versionMap, ok := version.(map[string]any)
+		if !ok || versionMap["name"] != gvr.Version {
+			continue
+		}
+
+		var definition struct {
+			AdditionalPrinterColumns []printerColumn `json:"additionalPrinterColumns"`
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(versionMap, &definition); err != nil {
+			return nil, err
+		}
+		if len(definition.AdditionalPrinterColumns) == 0 {
+			return defaultPrinterColumns, nil
+		}
+		return definition.AdditionalPrinterColumns, nil
+	}
+
+	return nil, nil
+}
+
+// isMinimalTable reports whether a table only has the name and age columns the API server falls back to
+func isMinimalTable(table *metav1.Table) bool {
+	for _, column := range table.ColumnDefinitions {
+		if !minimalTableColumns[column.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasExtraColumns reports whether printer columns add to the minimal table of the server
+func hasExtraColumns(columns []printerColumn) bool {
+	for _, column := range columns {
+		if !minimalTableColumns[column.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// newTablePrinter returns a printer with a name column followed by the given columns
+func newTablePrinter(columns []printerColumn) (*tablePrinter, error) {
+	tp := &tablePrinter{
+		columns: make([]metav1.TableColumnDefinition, 0, len(columns)+1),
+		paths:   make([]*jsonpath.JSONPath, 0, len(columns)),
+	}
+
+	tp.columns = append(tp.columns, metav1.TableColumnDefinition{
+		Name:        "Name",
+		Type:        "string",
+		Format:      "name",
+		Description: metav1.ObjectMeta{}.SwaggerDoc()["name"],
+	})
+
+	for _, column := range columns {
+		path := jsonpath.New(column.Name)
+		path.AllowMissingKeys(true)
+		if err := path.Parse(fmt.Sprintf("{%s}", column.JSONPath)); err != nil {
+			return nil, fmt.Errorf("invalid JSONPath of column %s: %w", column.Name, err)
+		}
+
+		tp.columns = append(tp.columns, metav1.TableColumnDefinition{
+			Name:        column.Name,
+			Type:        column.Type,
+			Format:      column.Format,
+			Description: column.Description,
+			Priority:    column.Priority,
+		})
+		tp.paths = append(tp.paths, path)
+	}
+
+	return tp, nil
+}
+
+// table builds the table of a list of objects, rows carrying the metadata of their object
+func (tp *tablePrinter) table(list *unstructured.UnstructuredList) *metav1.Table {
+	table := &metav1.Table{
+		ColumnDefinitions: tp.columns,
+		Rows:              make([]metav1.TableRow, 0, len(list.Items)),
+	}
+	table.ResourceVersion = list.GetResourceVersion()
+	table.Continue = list.GetContinue()
+
+	for i := range list.Items {
+		table.Rows = append(table.Rows, tp.row(&list.Items[i]))
+	}
+
+	return table
+}
+
+// row builds the row of an object, missing or mistyped values resulting in nil cells
+func (tp *tablePrinter) row(obj *unstructured.Unstructured) metav1.TableRow {
+	cells := make([]any, 0, len(tp.columns))
+	cells = append(cells, obj.GetName())
+
+	tp.pathsLock.Lock()
+	defer tp.pathsLock.Unlock()
+
+	var buf bytes.Buffer
+	for i, path := range tp.paths {
+		results, err := path.FindResults(obj.Object)
+		if err != nil || len(results) == 0 || len(results[0]) == 0 {
+			cells = append(cells, nil)
+			continue
+		}
+
+		// Printer columns only allow simple paths, the first result is the value
+		value := results[0][0].Interface()
+		if tp.columns[i+1].Type == "string" {
+			if err := path.PrintResults(&buf, []reflect.Value{reflect.ValueOf(value)}); err != nil {
+				cells = append(cells, nil)
+				continue
+			}
+			cells = append(cells, buf.String())
+			buf.Reset()
+		} else {
+			cells = append(cells, cellForJSONValue(tp.columns[i+1].Type, value))
+		}
+	}
+
+	pom := meta.AsPartialObjectMetadata(obj)
+	pom.TypeMeta = metav1.TypeMeta{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind()}
+
+	return metav1.TableRow{
+		Cells:  cells,
+		Object: runtime.RawExtension{Object: pom},
+	}
+}
+
+// cellForJSONValue converts a value to the type of its column, or nil if it has a different type
+func cellForJSONValue(columnType string, value any) any {
+	switch columnType {
+	case "integer":
+		switch typed := value.(type) {
+		case int64:
+			return typed
+		case float64:
+			return int64(typed)
+		case json.Number:
+			if i, err := typed.Int64(); err == nil {
+				return i
+			}
+		}
+	case "number":
+		switch typed := value.(type) {
+		case int64:
+			return float64(typed)
+		case float64:
+			return typed
+		case json.Number:
+			if f, err := typed.Float64(); err == nil {
+				return f
+			}
+		}
+	case "boolean":
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case "string":
+		if s, ok := value.(string); ok {
+			return s
+		}
+	case "date":
+		if s, ok := value.(string); ok {
+			var timestamp metav1.Time
+			if err := timestamp.UnmarshalQueryParameter(s); err != nil {
+				return "<invalid>"
+			}
+			return metatable.ConvertToHumanReadableDateType(timestamp)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rneacsu/spyglass/internal/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// ResourceWatcher keeps a single object up to date without listing the whole collection
+type ResourceWatcher struct {
+	*baseWatcher
+	client      dynamic.Interface
+	objLock     sync.RWMutex
+	obj         *unstructured.Unstructured
+	subscribers *broadcaster[ResourceEvent]
+}
+
+func NewResourceWatcher(clientConfig *rest.Config, config WatcherConfig) (*ResourceWatcher, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("resource watcher requires an object name")
+	}
+
+	client, err := dynamic.NewForConfig(clientConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newResourceWatcher(client, config), nil
+}
+
+func newResourceWatcher(client dynamic.Interface, config WatcherConfig) *ResourceWatcher {
+	return &ResourceWatcher{
+		baseWatcher: NewBaseWatcher(config, WatcherTypeResource),
+		client:      client,
+		subscribers: newBroadcaster[ResourceEvent](),
+	}
+}
+
+// Get returns the watched object, or a NotFound error if it does not exist
+func (rw *ResourceWatcher) Get(ctx context.Context) (*unstructured.Unstructured, error) {
+	rw.watchLock.Lock()
+	defer rw.watchLock.Unlock()
+
+	if err := rw.ensureWatch(ctx); err != nil {
+		return nil, err
+	}
+
+	rw.objLock.RLock()
+	defer rw.objLock.RUnlock()
+
+	if rw.obj == nil {
+		return nil, rw.notFound()
+	}
+
+	return rw.obj, nil
+}
+
+// Watch returns the watched object together with a subscription to its subsequent changes, including its
+// deletion and recreation. The subscription must be closed by the caller.
+func (rw *ResourceWatcher) Watch(ctx context.Context) (*unstructured.Unstructured, *Subscription[ResourceEvent], error) {
+	rw.watchLock.Lock()
+	defer rw.watchLock.Unlock()
+
+	if err := rw.ensureWatch(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// Take the object and subscribe under the same lock so no event is missed in between
+	rw.objLock.RLock()
+	defer rw.objLock.RUnlock()
+
+	if rw.obj == nil {
+		return nil, nil, rw.notFound()
+	}
+
+	return rw.obj, newSubscription(rw.baseWatcher, rw.subscribers), nil
+}
+
+func (rw *ResourceWatcher) notFound() error {
+	return apierrors.NewNotFound(rw.config.GVR.GroupResource(), rw.config.Name)
+}
+
+func (rw *ResourceWatcher) ensureWatch(ctx context.Context) error {
+	return rw.baseWatcher.ensureWatch(ctx, rw)
+}
+
+// list fetches the object through a list selecting its name, which has a resource version to watch from
+// even when the object does not exist. Subscribers are sent the changes missed while not watching.
+func (rw *ResourceWatcher) list(ctx context.Context) (string, error) {
+	listResult, err := resourceClient(rw.client, rw.config).List(ctx, metav1.ListOptions{
+		FieldSelector: rw.fieldSelector(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get (context: %s, resource: %s, namespace: %s, name: %s): %w", rw.config.KubeContext, rw.config.GVR, rw.config.Namespace, rw.config.Name, err)
+	}
+
+	var obj *unstructured.Unstructured
+	if len(listResult.Items) > 0 {
+		obj = &listResult.Items[0]
+	}
+
+	rw.objLock.Lock()
+	defer rw.objLock.Unlock()
+
+	previous := rw.obj
+	rw.obj = obj
+
+	switch {
+	case previous != nil && (obj == nil || obj.GetUID() != previous.GetUID()):
+		rw.subscribers.publish(ResourceEvent{Type: watch.Deleted, Object: previous})
+		if obj != nil {
+			rw.subscribers.publish(ResourceEvent{Type: watch.Added, Object: obj})
+		}
+	case previous == nil && obj != nil:
+		rw.subscribers.publish(ResourceEvent{Type: watch.Added, Object: obj})
+	case obj != nil && obj.GetResourceVersion() != previous.GetResourceVersion():
+		rw.subscribers.publish(ResourceEvent{Type: watch.Modified, Object: obj})
+	}
+
+	return listResult.GetResourceVersion(), nil
+}
+
+func (rw *ResourceWatcher) fieldSelector() string {
+	return fields.OneTermEqualSelector("metadata.name", rw.config.Name).String()
+}
+
+func (rw *ResourceWatcher) startWatch(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	timeout := int64(DefaultWatchTimeout.Seconds())
+	watchOpts := metav1.ListOptions{
+		FieldSelector:       rw.fieldSelector(),
+		ResourceVersion:     resourceVersion,
+		TimeoutSeconds:      &timeout,
+		AllowWatchBookmarks: true,
+	}
+	watcher, err := resourceClient(rw.client, rw.config).Watch(ctx, watchOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch (context: %s, resource: %s, namespace: %s, name: %s): %w", rw.config.KubeContext, rw.config.GVR, rw.config.Namespace, rw.config.Name, err)
+	}
+
+	return watcher, nil
+}
+
+func (rw *ResourceWatcher) handleEvent(event watch.Event) {
+	rw.objLock.Lock()
+	defer rw.objLock.Unlock()
+
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		rw.obj = event.Object.(*unstructured.Unstructured)
+	case watch.Deleted:
+		obj := event.Object.(*unstructured.Unstructured)
+		if rw.obj == nil || rw.obj.GetUID() != obj.GetUID() {
+			// Already removed when deleted through the connection
+			return
+		}
+		rw.obj = nil
+	case watch.Error:
+		logger.Errorw(watchErrorMessage(event), rw.logContext...)
+		return
+	default:
+		return
+	}
+
+	rw.subscribers.publish(ResourceEvent{
+		Type:   event.Type,
+		Object: event.Object.(*unstructured.Unstructured),
+	})
+}
+
+func (rw *ResourceWatcher) objectDeleted(uid types.UID) {
+	rw.objLock.Lock()
+	defer rw.objLock.Unlock()
+
+	if rw.obj == nil || rw.obj.GetUID() != uid {
+		return
+	}
+
+	rw.subscribers.publish(ResourceEvent{
+		Type:   watch.Deleted,
+		Object: rw.obj,
+	})
+	rw.obj = nil
+}
+
+func (rw *ResourceWatcher) resync() {
+	rw.subscribers.closeAll()
+}
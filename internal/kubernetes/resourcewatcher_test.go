@@ -0,0 +1,73 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// nextEvent waits for the next event of a subscription, failing if it is closed instead
+func nextEvent[T any](t *testing.T, sub *Subscription[T]) T {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if !ok {
+			t.Fatal("the subscription was closed, expected an event")
+		}
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	panic("unreachable")
+}
+
+func TestResourceWatcherRelistSendsChangesMissedWhileNotWatching(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newTestPod("a"))
+	watches := newControlledWatches(client)
+
+	rw := newResourceWatcher(client, WatcherConfig{KubeContext: "test", GVR: podsGVR, Namespace: "default", Name: "a"})
+	defer rw.Stop()
+
+	obj, sub, err := rw.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer sub.Close()
+	waitFor(t, watches.started, "the first watch")
+	if obj.GetName() != "a" {
+		t.Fatalf("Watch() = %s, want a", obj.GetName())
+	}
+
+	pods := client.Resource(podsGVR).Namespace("default")
+	if err := pods.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	watches.end()
+
+	// The subscriber is told about the deletion the relist found, and stays subscribed
+	event := nextEvent(t, sub)
+	if event.Type != watch.Deleted || event.Object.GetName() != "a" {
+		t.Fatalf("got %s of %s, want the deletion of a", event.Type, event.Object.GetName())
+	}
+	waitFor(t, watches.started, "the watch to be resumed")
+
+	recreated := newTestPod("a")
+	recreated.SetUID("uid-recreated")
+	if _, err := pods.Create(ctx, recreated, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	watches.end()
+
+	event = nextEvent(t, sub)
+	if event.Type != watch.Added || event.Object.GetUID() != "uid-recreated" {
+		t.Fatalf("got %s of %s, want the recreated object to be added", event.Type, event.Object.GetUID())
+	}
+
+	if _, err := rw.Get(ctx); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+}
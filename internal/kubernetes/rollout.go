@@ -0,0 +1,482 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RestartedAtAnnotation is the pod template annotation changed to restart a rollout, as kubectl does
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// ErrRolloutPaused is returned when restarting a paused Deployment, whose rollout would not start
+var ErrRolloutPaused = errors.New("rollout is paused")
+
+// rolloutResources are the workloads supporting rollouts
+var rolloutResources = map[schema.GroupResource]bool{
+	{Group: "apps", Resource: "deployments"}:  true,
+	{Group: "apps", Resource: "statefulsets"}: true,
+	{Group: "apps", Resource: "daemonsets"}:   true,
+}
+
+func checkRolloutResource(gvr schema.GroupVersionResource) error {
+	if !rolloutResources[gvr.GroupResource()] {
+		return apierrors.NewBadRequest(fmt.Sprintf("resource %s does not support rollouts", gvr.GroupResource()))
+	}
+	return nil
+}
+
+// RestartRollout triggers a rollout of a workload, replacing all its pods, by changing the restart
+// annotation of its pod template. Paused Deployments are refused, as with kubectl.
+func (kc *KubeConnection) RestartRollout(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string) (*unstructured.Unstructured, error) {
+	kc.UpdateLastUsed()
+
+	if err := checkRolloutResource(gvr); err != nil {
+		return nil, err
+	}
+
+	if gvr.Resource == "deployments" {
+		deployment, err := kc.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to restart rollout (context: %s, resource: %s, namespace: %s, name: %s): %w", kc.kubeContext, gvr, namespace, name, err)
+		}
+		if deployment.Spec.Paused {
+			return nil, fmt.Errorf("%w, resume it before restarting (context: %s, namespace: %s, name: %s)", ErrRolloutPaused, kc.kubeContext, namespace, name)
+		}
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						RestartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := resourceClient(kc.dynamic, WatcherConfig{GVR: gvr, Namespace: namespace}).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restart rollout (context: %s, resource: %s, namespace: %s, name: %s): %w", kc.kubeContext, gvr, namespace, name, err)
+	}
+
+	return obj, nil
+}
+
+const (
+	// deploymentRevisionAnnotation holds the revision of a Deployment and of its ReplicaSets
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+	// ChangeCauseAnnotation records the cause of a change of a workload, copied to its revisions
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// RolloutRevision is a revision of the pod template of a workload, backed by a ReplicaSet for Deployments
+// and by a ControllerRevision for StatefulSets and DaemonSets
+type RolloutRevision struct {
+	Revision    int64
+	Name        string
+	ChangeCause string
+	Created     time.Time
+	// Current tells whether the workload is rolled out to this revision
+	Current  bool
+	Template map[string]any
+}
+
+// TemplateChangeType is the kind of change of a field between two pod templates
+type TemplateChangeType string
+
+const (
+	TemplateChangeAdded    TemplateChangeType = "added"
+	TemplateChangeRemoved  TemplateChangeType = "removed"
+	TemplateChangeModified TemplateChangeType = "modified"
+)
+
+// TemplateChange is a change of a single field between two pod templates. Lists of named items, such as
+// containers, are matched by name, the path then reads as containers[name=app].image.
+type TemplateChange struct {
+	Path     string
+	Type     TemplateChangeType
+	OldValue any
+	NewValue any
+}
+
+// RolloutHistory returns the revisions of a workload, ordered by revision
+func (kc *KubeConnection) RolloutHistory(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string) ([]RolloutRevision, error) {
+	kc.UpdateLastUsed()
+
+	if err := checkRolloutResource(gvr); err != nil {
+		return nil, err
+	}
+
+	var revisions []RolloutRevision
+	var err error
+	switch gvr.Resource {
+	case "deployments":
+		revisions, err = kc.deploymentHistory(ctx, namespace, name)
+	default:
+		revisions, err = kc.controllerRevisionHistory(ctx, gvr.Resource, namespace, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollout history (context: %s, resource: %s, namespace: %s, name: %s): %w", kc.kubeContext, gvr, namespace, name, err)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	return revisions, nil
+}
+
+func (kc *KubeConnection) deploymentHistory(ctx context.Context, namespace string, name string) ([]RolloutRevision, error) {
+	deployment, err := kc.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaSets, err := kc.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]RolloutRevision, 0, len(replicaSets.Items))
+	for _, rs := range replicaSets.Items {
+		if !metav1.IsControlledBy(&rs, deployment) {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[deploymentRevisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		template, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&rs.Spec.Template)
+		if err != nil {
+			return nil, err
+		}
+		// The hash label differs between every ReplicaSet, it is not part of the template of the Deployment
+		unstructured.RemoveNestedField(template, "metadata", "labels", appsv1.DefaultDeploymentUniqueLabelKey)
+		unstructured.RemoveNestedField(template, "metadata", "creationTimestamp")
+
+		revisions = append(revisions, RolloutRevision{
+			Revision:    revision,
+			Name:        rs.Name,
+			ChangeCause: rs.Annotations[ChangeCauseAnnotation],
+			Created:     rs.CreationTimestamp.Time,
+			Current:     rs.Annotations[deploymentRevisionAnnotation] == deployment.Annotations[deploymentRevisionAnnotation],
+			Template:    template,
+		})
+	}
+
+	return revisions, nil
+}
+
+func (kc *KubeConnection) controllerRevisionHistory(ctx context.Context, resource string, namespace string, name string) ([]RolloutRevision, error) {
+	var owner metav1.Object
+	var labelSelector *metav1.LabelSelector
+	currentRevision := ""
+	switch resource {
+	case "statefulsets":
+		statefulSet, err := kc.clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owner, labelSelector, currentRevision = statefulSet, statefulSet.Spec.Selector, statefulSet.Status.UpdateRevision
+	case "daemonsets":
+		daemonSet, err := kc.clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owner, labelSelector = daemonSet, daemonSet.Spec.Selector
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	controllerRevisions, err := kc.clientset.AppsV1().ControllerRevisions(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]RolloutRevision, 0, len(controllerRevisions.Items))
+	var latest *RolloutRevision
+	for _, cr := range controllerRevisions.Items {
+		if !metav1.IsControlledBy(&cr, owner) {
+			continue
+		}
+
+		// The data of a revision is a patch replacing the pod template of the workload
+		var data map[string]any
+		if err := json.Unmarshal(cr.Data.Raw, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode controller revision %s: %w", cr.Name, err)
+		}
+		template, _, _ := unstructured.NestedMap(data, "spec", "template")
+		delete(template, "$patch")
+
+		revisions = append(revisions, RolloutRevision{
+			Revision:    cr.Revision,
+			Name:        cr.Name,
+			ChangeCause: cr.Annotations[ChangeCauseAnnotation],
+			Created:     cr.CreationTimestamp.Time,
+			Current:     cr.Name == currentRevision,
+			Template:    template,
+		})
+		if latest == nil || cr.Revision > latest.Revision {
+			latest = &revisions[len(revisions)-1]
+		}
+	}
+
+	// DaemonSets do not report their revision, they always roll out to the latest one
+	if currentRevision == "" && latest != nil {
+		latest.Current = true
+	}
+
+	return revisions, nil
+}
+
+// RollbackRollout rolls a workload back to the pod template of a revision, the one preceding the current
+// revision when zero. The workload takes the change cause of the revision, as with kubectl.
+func (kc *KubeConnection) RollbackRollout(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string, revision int64) (*unstructured.Unstructured, error) {
+	revisions, err := kc.RolloutHistory(ctx, gvr, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := findRevision(revisions, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	client := resourceClient(kc.dynamic, WatcherConfig{GVR: gvr, Namespace: namespace})
+	current, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if target.Current {
+		// Already rolled out to the revision
+		return current, nil
+	}
+
+	if paused, _, _ := unstructured.NestedBool(current.Object, "spec", "paused"); paused && gvr.Resource == "deployments" {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("deployment %s is paused, resume it before rolling back", name))
+	}
+
+	// The annotations are replaced as a whole, a JSON patch cannot add a key to missing annotations
+	annotations := current.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if target.ChangeCause != "" {
+		annotations[ChangeCauseAnnotation] = target.ChangeCause
+	} else {
+		delete(annotations, ChangeCauseAnnotation)
+	}
+
+	patch, err := json.Marshal([]map[string]any{
+		{
+			"op":    "replace",
+			"path":  "/spec/template",
+			"value": target.Template,
+		},
+		{
+			"op":    "add",
+			"path":  "/metadata/annotations",
+			"value": annotations,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := client.Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back (context: %s, resource: %s, namespace: %s, name: %s, revision: %d): %w", kc.kubeContext, gvr, namespace, name, target.Revision, err)
+	}
+
+	return obj, nil
+}
+
+// DiffRolloutRevisions returns the changes of the pod template of a workload from one revision to another
+func (kc *KubeConnection) DiffRolloutRevisions(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string, fromRevision int64, toRevision int64) ([]TemplateChange, error) {
+	revisions, err := kc.RolloutHistory(ctx, gvr, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := findRevision(revisions, fromRevision)
+	if err != nil {
+		return nil, err
+	}
+	to, err := findRevision(revisions, toRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]TemplateChange, 0)
+	diffValues("", from.Template, to.Template, &changes)
+
+	return changes, nil
+}
+
+// findRevision returns a revision of the history, the one preceding the current revision when zero
+func findRevision(revisions []RolloutRevision, revision int64) (*RolloutRevision, error) {
+	if revision == 0 {
+		current := -1
+		for i := range revisions {
+			if revisions[i].Current {
+				current = i
+			}
+		}
+		if current <= 0 {
+			return nil, apierrors.NewBadRequest("no previous revision to roll back to")
+		}
+		return &revisions[current-1], nil
+	}
+
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			return &revisions[i], nil
+		}
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "revisions"}, strconv.FormatInt(revision, 10))
+}
+
+// diffValues appends the changes between two decoded JSON values
+func diffValues(path string, oldValue any, newValue any, changes *[]TemplateChange) {
+	switch {
+	case oldValue == nil && newValue == nil:
+		return
+	case oldValue == nil:
+		*changes = append(*changes, TemplateChange{Path: path, Type: TemplateChangeAdded, NewValue: newValue})
+		return
+	case newValue == nil:
+		*changes = append(*changes, TemplateChange{Path: path, Type: TemplateChangeRemoved, OldValue: oldValue})
+		return
+	}
+
+	oldMap, oldIsMap := oldValue.(map[string]any)
+	newMap, newIsMap := newValue.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for key := range oldMap {
+			keys = append(keys, key)
+		}
+		for key := range newMap {
+			if _, ok := oldMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			diffValues(joinPath(path, key), oldMap[key], newMap[key], changes)
+		}
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]any)
+	newList, newIsList := newValue.([]any)
+	if oldIsList && newIsList {
+		diffLists(path, oldList, newList, changes)
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, TemplateChange{Path: path, Type: TemplateChangeModified, OldValue: oldValue, NewValue: newValue})
+	}
+}
+
+// diffLists matches the items of lists of named objects by name, and other lists by index
+func diffLists(path string, oldList []any, newList []any, changes *[]TemplateChange) {
+	oldNames, oldNamed := listItemNames(oldList)
+	newNames, newNamed := listItemNames(newList)
+	if !oldNamed || !newNamed {
+		for i := 0; i < max(len(oldList), len(newList)); i++ {
+			var oldItem, newItem any
+			if i < len(oldList) {
+				oldItem = oldList[i]
+			}
+			if i < len(newList) {
+				newItem = newList[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), oldItem, newItem, changes)
+		}
+		return
+	}
+
+	oldItems := make(map[string]any, len(oldList))
+	for i, name := range oldNames {
+		oldItems[name] = oldList[i]
+	}
+	newItems := make(map[string]any, len(newList))
+	for i, name := range newNames {
+		newItems[name] = newList[i]
+	}
+
+	for i, name := range oldNames {
+		diffValues(fmt.Sprintf("%s[name=%s]", path, name), oldList[i], newItems[name], changes)
+	}
+	for i, name := range newNames {
+		if _, ok := oldItems[name]; !ok {
+			diffValues(fmt.Sprintf("%s[name=%s]", path, name), nil, newList[i], changes)
+		}
+	}
+}
+
+// listItemNames returns the names of the items of a list when all of them are objects with a unique name
+func listItemNames(list []any) ([]string, bool) {
+	names := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		name, ok := itemMap["name"].(string)
+		if !ok || seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, true
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
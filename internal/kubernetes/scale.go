@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Scale is the state of the scale subresource of a resource
+type Scale struct {
+	// Replicas is the desired number of replicas
+	Replicas int32
+	// CurrentReplicas is the number of replicas last observed
+	CurrentReplicas int32
+	// Selector is the label selector of the replicas
+	Selector string
+}
+
+// ScaleResource sets the desired number of replicas of any resource exposing the scale subresource
+func (kc *KubeConnection) ScaleResource(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string, replicas int32) (Scale, error) {
+	kc.UpdateLastUsed()
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		return Scale{}, err
+	}
+
+	obj, err := resourceClient(kc.dynamic, WatcherConfig{GVR: gvr, Namespace: namespace}).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	}, "scale")
+	if err != nil {
+		return Scale{}, fmt.Errorf("failed to scale (context: %s, resource: %s, namespace: %s, name: %s): %w", kc.kubeContext, gvr, namespace, name, err)
+	}
+
+	return decodeScale(obj), nil
+}
+
+// decodeScale reads an autoscaling/v1 Scale object
+func decodeScale(obj *unstructured.Unstructured) Scale {
+	replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	currentReplicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	selector, _, _ := unstructured.NestedString(obj.Object, "status", "selector")
+
+	return Scale{
+		Replicas:        int32(replicas),
+		CurrentReplicas: int32(currentReplicas),
+		Selector:        selector,
+	}
+}
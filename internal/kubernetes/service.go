@@ -2,11 +2,15 @@ package kubernetes
 
 import (
 	"context"
+	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/rneacsu/spyglass/internal/logger"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/tools/clientcmd"
@@ -19,8 +23,10 @@ const (
 )
 
 type KubeService struct {
-	kubeConfig  *api.Config
-	connections map[string]*KubeConnection
+	kubeConfig      *api.Config
+	connectionsLock sync.Mutex
+	connections     map[string]*KubeConnection
+	customColumns   *customColumnStore
 }
 
 func NewKubeService() *KubeService {
@@ -32,13 +38,19 @@ func NewKubeService() *KubeService {
 	}
 
 	return &KubeService{
-		kubeConfig:  kubeConfig,
-		connections: make(map[string]*KubeConnection),
+		kubeConfig:    kubeConfig,
+		connections:   make(map[string]*KubeConnection),
+		customColumns: newCustomColumnStore(),
 	}
 }
 
 func (ks *KubeService) Stop() {
-	for _, conn := range ks.connections {
+	ks.connectionsLock.Lock()
+	connections := ks.connections
+	ks.connections = make(map[string]*KubeConnection)
+	ks.connectionsLock.Unlock()
+
+	for _, conn := range connections {
 		conn.Stop()
 	}
 }
@@ -58,8 +70,14 @@ func (ks *KubeService) GetDefaultContext() string {
 	return ks.kubeConfig.CurrentContext
 }
 
+// getConnection returns the connection for the context, creating it if needed. The connection is
+// protected from eviction until the caller releases it.
 func (ks *KubeService) getConnection(kubeContext string) (*KubeConnection, error) {
+	ks.connectionsLock.Lock()
+	defer ks.connectionsLock.Unlock()
+
 	if connection, ok := ks.connections[kubeContext]; ok {
+		connection.acquire()
 		return connection, nil
 	}
 
@@ -68,77 +86,661 @@ func (ks *KubeService) getConnection(kubeContext string) (*KubeConnection, error
 		var oldestConnection *KubeConnection
 		var oldestKey string
 		for k, c := range ks.connections {
-			if oldestConnection == nil || c.LastUsed.Before(oldestConnection.LastUsed) {
+			if c.inUse() {
+				continue
+			}
+			if oldestConnection == nil || c.GetLastUsed().Before(oldestConnection.GetLastUsed()) {
 				oldestConnection = c
 				oldestKey = k
 			}
 		}
 
-		oldestConnection.Stop()
-		delete(ks.connections, oldestKey)
+		if oldestConnection != nil {
+			delete(ks.connections, oldestKey)
+			// Stopping waits for all watchers and port forwards, do not block other requests meanwhile
+			go oldestConnection.Stop()
+		} else {
+			logger.Warnw("all connections are in use, exceeding the connection limit", "limit", MaxConnections)
+		}
 	}
 
-	connection, err := NewKubeConnection(ks.kubeConfig, kubeContext)
+	connection, err := NewKubeConnection(ks.kubeConfig, kubeContext, ks.customColumns)
 
 	if err != nil {
 		return nil, err
 	}
 
+	connection.acquire()
 	ks.connections[kubeContext] = connection
 
 	return connection, nil
 }
 
-func (ks *KubeService) Discover(ctx context.Context, kubeContext string) ([]*metav1.APIResourceList, error) {
+func (ks *KubeService) Discover(ctx context.Context, kubeContext string) (*Discovery, error) {
 	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.release()
 
-	resources, err := conn.Discover(ctx)
+	discovery, err := conn.Discover(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return resources, nil
+	return discovery, nil
 }
 
-func (ks *KubeService) ListResource(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string) ([]*unstructured.Unstructured, error) {
+// InvalidateDiscovery drops the cached discovery information of a context
+func (ks *KubeService) InvalidateDiscovery(kubeContext string) error {
 	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer conn.release()
+
+	conn.InvalidateDiscovery()
 
-	watcher, err := conn.GetWatcher(gvr, namespace, WatcherTypeList)
+	return nil
+}
 
+// ResourceQuery selects the resources listed or watched by the service
+type ResourceQuery struct {
+	GVR schema.GroupVersionResource
+	// Namespaces are watched separately for users without cluster-wide permissions.
+	// No namespaces, or a single empty one, selects the whole cluster.
+	Namespaces    []string
+	LabelSelector string
+	FieldSelector string
+}
+
+// NormalizeSelectors validates the selectors of the query and rewrites them in canonical form,
+// so equivalent selectors share the same watchers
+func (q *ResourceQuery) NormalizeSelectors() error {
+	labelSelector, err := labels.Parse(q.LabelSelector)
+	if err != nil {
+		return fmt.Errorf("invalid label selector: %w", err)
+	}
+	fieldSelector, err := fields.ParseSelector(q.FieldSelector)
+	if err != nil {
+		return fmt.Errorf("invalid field selector: %w", err)
+	}
+
+	q.LabelSelector = labelSelector.String()
+	q.FieldSelector = fieldSelector.String()
+
+	return nil
+}
+
+func (ks *KubeService) ListResource(ctx context.Context, kubeContext string, query ResourceQuery) ([]*unstructured.Unstructured, error) {
+	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.release()
 
-	objects, err := watcher.(*ListWatcher).List(ctx)
+	watchers, err := conn.GetQueryWatchers(query, WatcherTypeList)
 	if err != nil {
 		return nil, err
 	}
+	defer releaseWatchers(watchers)
+
+	objects := make([]*unstructured.Unstructured, 0)
+	for _, watcher := range watchers {
+		objs, err := watcher.(*ListWatcher).List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, objs...)
+	}
+
+	if len(watchers) > 1 {
+		sortObjects(objects)
+	}
 
 	return objects, nil
 }
 
-func (ks *KubeService) ListResourceTabular(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string) (*metav1.Table, error) {
+func (ks *KubeService) ListResourceTabular(ctx context.Context, kubeContext string, query ResourceQuery) (*metav1.Table, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	watchers, err := conn.GetQueryWatchers(query, WatcherTypeTable)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseWatchers(watchers)
+
+	tables := make([]*metav1.Table, 0, len(watchers))
+	for _, watcher := range watchers {
+		table, err := watcher.(*TableWatcher).GetTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	return mergeTables(tables), nil
+}
+
+// ListResourcePage fetches a single page of resources straight from the API server, so large collections
+// can be rendered before they are fully loaded. The returned token is empty on the last page.
+func (ks *KubeService) ListResourcePage(ctx context.Context, kubeContext string, query ResourceQuery, pageSize int64, continueToken string) ([]*unstructured.Unstructured, string, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.release()
+
+	// Pages are fetched without watchers, paging through a collection must not evict the watched ones
+	objects := make([]*unstructured.Unstructured, 0, pageSize)
+	next, err := paginate(conn.queryConfigs(query), pageSize, continueToken, func(config WatcherConfig, limit int64, continueToken string) (string, int, error) {
+		list, err := listPage(ctx, conn.dynamic, config, limit, continueToken)
+		if err != nil {
+			return "", 0, err
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+		return list.GetContinue(), len(list.Items), nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return objects, next, nil
+}
+
+// ListResourceTabularPage is the tabular counterpart of ListResourcePage
+func (ks *KubeService) ListResourceTabularPage(ctx context.Context, kubeContext string, query ResourceQuery, pageSize int64, continueToken string) (*metav1.Table, string, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.release()
+
+	evaluator := ks.customColumns.evaluator(query.GVR)
+
+	table := &metav1.Table{}
+	next, err := paginate(conn.queryConfigs(query), pageSize, continueToken, func(config WatcherConfig, limit int64, continueToken string) (string, int, error) {
+		lister, err := newTableLister(conn.clientConfig, config, conn.printers)
+		if err != nil {
+			return "", 0, err
+		}
+		page, err := lister.ListPage(ctx, limit, continueToken, evaluator)
+		if err != nil {
+			return "", 0, err
+		}
+		if table.ColumnDefinitions == nil {
+			table.ColumnDefinitions = page.ColumnDefinitions
+		}
+		table.Rows = append(table.Rows, page.Rows...)
+		return page.Continue, len(page.Rows), nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return table, next, nil
+}
+
+func (ks *KubeService) WatchResource(ctx context.Context, kubeContext string, query ResourceQuery) (*unstructured.UnstructuredList, *Subscription[ResourceEvent], error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.release()
+
+	watchers, err := conn.GetQueryWatchers(query, WatcherTypeList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list := &unstructured.UnstructuredList{}
+	subs := make([]*Subscription[ResourceEvent], 0, len(watchers))
+	for _, watcher := range watchers {
+		l, sub, err := watcher.(*ListWatcher).Watch(ctx)
+		if err != nil {
+			closeSubscriptions(subs)
+			releaseWatchers(watchers)
+			return nil, nil, err
+		}
+		subs = append(subs, sub)
+
+		list.Items = append(list.Items, l.Items...)
+		list.SetResourceVersion(l.GetResourceVersion())
+	}
+
+	if len(watchers) > 1 {
+		// Resource versions are only meaningful per watch
+		list.SetResourceVersion("")
+		sort.SliceStable(list.Items, func(i, j int) bool {
+			return list.Items[i].GetName() < list.Items[j].GetName()
+		})
+	}
+
+	return list, streamUntilClosed(mergeSubscriptions(subs), watchers), nil
+}
+
+func (ks *KubeService) WatchResourceTabular(ctx context.Context, kubeContext string, query ResourceQuery) (*metav1.Table, *Subscription[TableEvent], error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.release()
+
+	watchers, err := conn.GetQueryWatchers(query, WatcherTypeTable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tables := make([]*metav1.Table, 0, len(watchers))
+	subs := make([]*Subscription[TableEvent], 0, len(watchers))
+	for _, watcher := range watchers {
+		table, sub, err := watcher.(*TableWatcher).Watch(ctx)
+		if err != nil {
+			closeSubscriptions(subs)
+			releaseWatchers(watchers)
+			return nil, nil, err
+		}
+		tables = append(tables, table)
+		subs = append(subs, sub)
+	}
+
+	return mergeTables(tables), streamUntilClosed(mergeSubscriptions(subs), watchers), nil
+}
+
+// ListCustomColumns returns the custom columns of every resource
+func (ks *KubeService) ListCustomColumns() []CustomColumnSet {
+	return ks.customColumns.List()
+}
+
+// SetCustomColumns replaces the custom columns of a resource, no columns removing them. The watched tables
+// of the resource are relisted with the new columns.
+func (ks *KubeService) SetCustomColumns(gvr schema.GroupVersionResource, columns []CustomColumn) error {
+	if err := ks.customColumns.Set(gvr, columns); err != nil {
+		return err
+	}
+
+	ks.refreshCustomColumns([]schema.GroupVersionResource{gvr})
+	return nil
+}
+
+// ImportCustomColumns loads the custom columns of a YAML preset, replacing the columns of the resources it
+// defines, or all columns when replace is set
+func (ks *KubeService) ImportCustomColumns(preset []byte, replace bool) error {
+	previous := ks.customColumns.List()
+	if err := ks.customColumns.Import(preset, replace); err != nil {
+		return err
+	}
+
+	// Both the resources losing their columns and the ones getting new columns change
+	gvrs := make([]schema.GroupVersionResource, 0)
+	for _, set := range append(previous, ks.customColumns.List()...) {
+		gvrs = append(gvrs, set.GVR())
+	}
+	ks.refreshCustomColumns(gvrs)
+	return nil
+}
+
+// refreshCustomColumns relists the watched tables of resources whose custom columns changed
+func (ks *KubeService) refreshCustomColumns(gvrs []schema.GroupVersionResource) {
+	ks.connectionsLock.Lock()
+	connections := make([]*KubeConnection, 0, len(ks.connections))
+	for _, conn := range ks.connections {
+		connections = append(connections, conn)
+	}
+	ks.connectionsLock.Unlock()
+
+	seen := make(map[schema.GroupVersionResource]bool, len(gvrs))
+	for _, gvr := range gvrs {
+		if seen[gvr] {
+			continue
+		}
+		seen[gvr] = true
+
+		for _, conn := range connections {
+			conn.RefreshCustomColumns(gvr)
+		}
+	}
+}
+
+// ExportCustomColumns returns the custom columns of every resource as a YAML preset
+func (ks *KubeService) ExportCustomColumns() ([]byte, error) {
+	return ks.customColumns.Export()
+}
+
+func (ks *KubeService) GetResource(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string) (*unstructured.Unstructured, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	watcher, err := conn.GetWatcher(WatcherConfig{
+		GVR:       gvr,
+		Namespace: namespace,
+		Name:      name,
+	}, WatcherTypeResource)
+
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.release()
+
+	return watcher.(*ResourceWatcher).Get(ctx)
+}
+
+// WatchResourceObject returns a single object together with a subscription to its subsequent changes, so a
+// details view stays live without watching the whole collection
+func (ks *KubeService) WatchResourceObject(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string) (*unstructured.Unstructured, *Subscription[ResourceEvent], error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.release()
+
+	watcher, err := conn.GetWatcher(WatcherConfig{
+		GVR:       gvr,
+		Namespace: namespace,
+		Name:      name,
+	}, WatcherTypeResource)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj, sub, err := watcher.(*ResourceWatcher).Watch(ctx)
+	if err != nil {
+		watcher.release()
+		return nil, nil, err
+	}
+
+	return obj, streamUntilClosed(sub, []Watcher{watcher}), nil
+}
+
+// UpdateResource replaces a resource with the object of a YAML or JSON manifest, see KubeConnection.UpdateResource
+func (ks *KubeService) UpdateResource(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, manifest []byte, dryRun bool) (*unstructured.Unstructured, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	return conn.UpdateResource(ctx, gvr, manifest, dryRun)
+}
+
+// ApplyManifest applies the objects of a YAML or JSON manifest through server-side apply, see KubeConnection.ApplyManifest
+func (ks *KubeService) ApplyManifest(ctx context.Context, kubeContext string, manifest []byte, opts ApplyOptions) ([]ApplyResult, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	return conn.ApplyManifest(ctx, manifest, opts)
+}
+
+// DeleteResource deletes a single object, see KubeConnection.DeleteResource
+func (ks *KubeService) DeleteResource(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string, opts DeleteOptions) (DeleteResult, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	defer conn.release()
+
+	return conn.DeleteResource(ctx, gvr, namespace, name, opts)
+}
+
+// DeleteResources deletes every object matching the query, see KubeConnection.DeleteResources
+func (ks *KubeService) DeleteResources(ctx context.Context, kubeContext string, query ResourceQuery, opts DeleteOptions) ([]DeleteResult, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	return conn.DeleteResources(ctx, query, opts)
+}
+
+// ScaleResource sets the desired number of replicas of a resource, see KubeConnection.ScaleResource
+func (ks *KubeService) ScaleResource(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string, replicas int32) (Scale, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return Scale{}, err
+	}
+	defer conn.release()
+
+	return conn.ScaleResource(ctx, gvr, namespace, name, replicas)
+}
+
+// RestartRollout triggers a rollout of a workload, see KubeConnection.RestartRollout
+func (ks *KubeService) RestartRollout(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string) (*unstructured.Unstructured, error) {
 	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.release()
 
-	watcher, err := conn.GetWatcher(gvr, namespace, WatcherTypeTable)
+	return conn.RestartRollout(ctx, gvr, namespace, name)
+}
 
+// RolloutHistory returns the revisions of a workload, see KubeConnection.RolloutHistory
+func (ks *KubeService) RolloutHistory(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string) ([]RolloutRevision, error) {
+	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.release()
+
+	return conn.RolloutHistory(ctx, gvr, namespace, name)
+}
 
-	table, err := watcher.(*TableWatcher).GetTable(ctx)
+// RollbackRollout rolls a workload back to a revision, see KubeConnection.RollbackRollout
+func (ks *KubeService) RollbackRollout(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string, revision int64) (*unstructured.Unstructured, error) {
+	conn, err := ks.getConnection(kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.release()
+
+	return conn.RollbackRollout(ctx, gvr, namespace, name, revision)
+}
+
+// DiffRolloutRevisions compares the pod templates of two revisions, see KubeConnection.DiffRolloutRevisions
+func (ks *KubeService) DiffRolloutRevisions(ctx context.Context, kubeContext string, gvr schema.GroupVersionResource, namespace string, name string, fromRevision int64, toRevision int64) ([]TemplateChange, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	return conn.DiffRolloutRevisions(ctx, gvr, namespace, name, fromRevision, toRevision)
+}
+
+// CordonNode marks a node as unschedulable, or schedulable again
+func (ks *KubeService) CordonNode(ctx context.Context, kubeContext string, name string, unschedulable bool) (*unstructured.Unstructured, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+
+	return conn.CordonNode(ctx, name, unschedulable)
+}
+
+// DrainNode cordons a node and evicts its pods, see KubeConnection.DrainNode
+func (ks *KubeService) DrainNode(ctx context.Context, kubeContext string, name string, opts DrainOptions, handle func(event DrainEvent) error) error {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return err
+	}
+	defer conn.release()
+
+	return conn.DrainNode(ctx, name, opts, handle)
+}
+
+// StreamPodLogs calls handle for every log line of a pod container until the logs end, the context
+// is canceled or handle fails. Canceling the context closes the upstream stream.
+func (ks *KubeService) StreamPodLogs(ctx context.Context, kubeContext string, namespace string, pod string, opts LogOptions, handle func(line string) error) error {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return err
+	}
+
+	stream, err := conn.StreamPodLogs(ctx, namespace, pod, opts)
+	// The log stream does not depend on the connection staying cached
+	conn.release()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return readLogLines(ctx, stream, handle)
+}
+
+// TailPodLogs calls handle for the log lines of every container of the pods matching the query,
+// attaching to new pods as they appear and detaching from deleted ones, until the context is canceled
+// or handle fails
+func (ks *KubeService) TailPodLogs(ctx context.Context, kubeContext string, query ResourceQuery, opts TailOptions, handle func(line LogLine) error) error {
+	query.GVR = podsGVR
+
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return err
+	}
+	// Pods keep being attached to through the connection, it stays in use until the tail ends
+	defer conn.release()
+
+	tailer := newPodLogTailer(conn, opts)
+	defer tailer.stop()
+
+	for {
+		list, sub, err := ks.WatchResource(ctx, kubeContext, query)
+		if err != nil {
+			return err
+		}
+
+		tailer.reconcile(ctx, list.Items)
+		err = tailer.run(ctx, sub.Events, handle)
+		sub.Close()
+
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+
+		// The pod watch relisted or fell behind, resubscribe and reconcile with a fresh snapshot
+	}
+}
+
+// ExecPod runs a command in a pod container, connecting its standard streams, and returns the exit code of
+// the command once it ends
+func (ks *KubeService) ExecPod(ctx context.Context, kubeContext string, namespace string, pod string, opts ExecOptions, streams ExecStreams) (int, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return 0, err
+	}
+
+	executor, err := conn.podExecutor(namespace, pod, opts)
+	// The exec session does not depend on the connection staying cached
+	conn.release()
+	if err != nil {
+		return 0, err
+	}
+
+	exitCode, err := runExecutor(ctx, executor, opts, streams)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute command (context: %s, namespace: %s, pod: %s, container: %s): %w", kubeContext, namespace, pod, opts.Container, err)
+	}
+
+	return exitCode, nil
+}
+
+// StartPortForward forwards a local port to a pod or service of a context. The forward is stopped along
+// with the connection of the context.
+func (ks *KubeService) StartPortForward(kubeContext string, opts PortForwardOptions) (PortForwardStatus, error) {
+	conn, err := ks.getConnection(kubeContext)
+	if err != nil {
+		return PortForwardStatus{}, err
+	}
+	defer conn.release()
+
+	return conn.StartPortForward(opts)
+}
+
+// ListPortForwards returns the active port forwards of a context, or of all contexts when none is given
+func (ks *KubeService) ListPortForwards(kubeContext string) []PortForwardStatus {
+	ks.connectionsLock.Lock()
+	connections := make([]*KubeConnection, 0, len(ks.connections))
+	for name, conn := range ks.connections {
+		if kubeContext == "" || name == kubeContext {
+			connections = append(connections, conn)
+		}
+	}
+	ks.connectionsLock.Unlock()
+
+	statuses := make([]PortForwardStatus, 0)
+	for _, conn := range connections {
+		statuses = append(statuses, conn.PortForwards()...)
+	}
+	sortPortForwards(statuses)
+
+	return statuses
+}
+
+// StopPortForward stops a port forward of any context
+func (ks *KubeService) StopPortForward(id string) error {
+	ks.connectionsLock.Lock()
+	connections := make([]*KubeConnection, 0, len(ks.connections))
+	for _, conn := range ks.connections {
+		connections = append(connections, conn)
+	}
+	ks.connectionsLock.Unlock()
+
+	for _, conn := range connections {
+		if conn.StopPortForward(id) {
+			return nil
+		}
+	}
+
+	return portForwardNotFound(id)
+}
+
+func releaseWatchers(watchers []Watcher) {
+	for _, watcher := range watchers {
+		watcher.release()
+	}
+}
+
+// sortObjects sorts objects by name, keeping the namespace order for equal names
+func sortObjects(objects []*unstructured.Unstructured) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].GetName() < objects[j].GetName()
+	})
+}
+
+// mergeTables concatenates the rows of tables of the same resource, sorted by name
+func mergeTables(tables []*metav1.Table) *metav1.Table {
+	if len(tables) == 1 {
+		return tables[0]
+	}
+
+	merged := &metav1.Table{}
+	for _, table := range tables {
+		if merged.ColumnDefinitions == nil {
+			merged.ColumnDefinitions = table.ColumnDefinitions
+		}
+		merged.Rows = append(merged.Rows, table.Rows...)
+	}
+
+	sort.SliceStable(merged.Rows, func(i, j int) bool {
+		aName := merged.Rows[i].Object.Object.(*metav1.PartialObjectMetadata).Name
+		bName := merged.Rows[j].Object.Object.(*metav1.PartialObjectMetadata).Name
+		return aName < bName
+	})
 
-	return table, nil
+	return merged
 }
@@ -0,0 +1,357 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testPodsPerNamespace is the number of pods served by the fake API server in every namespace
+const testPodsPerNamespace = 3
+
+// newFakeAPIServer serves lists, tables and never-ending watches of the pods of any namespace
+func newFakeAPIServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, namespaced := strings.CutPrefix(r.URL.Path, "/api/v1/namespaces/")
+		namespace, pods := strings.CutSuffix(namespace, "/pods")
+		if !namespaced || !pods {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("watch") == "true" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+
+		var body any
+		if strings.Contains(r.Header.Get("Accept"), "as=Table") {
+			body = fakePodTable(namespace)
+		} else {
+			body = fakePodList(namespace)
+		}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			t.Errorf("failed to encode response: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func fakePodList(namespace string) map[string]any {
+	items := make([]any, 0, testPodsPerNamespace)
+	for i := 0; i < testPodsPerNamespace; i++ {
+		items = append(items, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]any{
+				"namespace":       namespace,
+				"name":            fmt.Sprintf("pod-%d", i),
+				"uid":             fmt.Sprintf("%s-%d", namespace, i),
+				"resourceVersion": "1",
+			},
+		})
+	}
+
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "PodList",
+		"metadata":   map[string]any{"resourceVersion": "1"},
+		"items":      items,
+	}
+}
+
+func fakePodTable(namespace string) *metav1.Table {
+	table := &metav1.Table{
+		TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"},
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name"},
+			{Name: "Status", Type: "string"},
+		},
+	}
+	table.ResourceVersion = "1"
+
+	for i := 0; i < testPodsPerNamespace; i++ {
+		pom := &metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       namespace,
+				Name:            fmt.Sprintf("pod-%d", i),
+				UID:             types.UID(fmt.Sprintf("%s-%d", namespace, i)),
+				ResourceVersion: "1",
+			},
+		}
+		table.Rows = append(table.Rows, metav1.TableRow{
+			Cells:  []any{pom.Name, "Running"},
+			Object: runtime.RawExtension{Object: pom},
+		})
+	}
+
+	return table
+}
+
+// newTestKubeService returns a service with one context per fake API server
+func newTestKubeService(t *testing.T, contexts []string) *KubeService {
+	t.Setenv(CacheDirEnv, t.TempDir())
+	t.Setenv(ConfigDirEnv, t.TempDir())
+
+	kubeConfig := api.NewConfig()
+	kubeConfig.AuthInfos["test"] = api.NewAuthInfo()
+	for _, name := range contexts {
+		cluster := api.NewCluster()
+		cluster.Server = newFakeAPIServer(t).URL
+		kubeConfig.Clusters[name] = cluster
+
+		context := api.NewContext()
+		context.Cluster = name
+		context.AuthInfo = "test"
+		kubeConfig.Contexts[name] = context
+	}
+
+	ks := &KubeService{
+		kubeConfig:    kubeConfig,
+		connections:   make(map[string]*KubeConnection),
+		customColumns: newCustomColumnStore(),
+	}
+	// Stop the watchers before the fake API servers, which wait for the watches to end
+	t.Cleanup(ks.Stop)
+
+	return ks
+}
+
+func TestListResourceConcurrentlyAcrossContexts(t *testing.T) {
+	contexts := []string{"one", "two", "three"}
+	ks := newTestKubeService(t, contexts)
+
+	query := ResourceQuery{GVR: podsGVR, Namespaces: []string{"a", "b"}}
+	want := len(query.Namespaces) * testPodsPerNamespace
+
+	var wg sync.WaitGroup
+	for _, kubeContext := range contexts {
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for range 5 {
+					objects, err := ks.ListResource(t.Context(), kubeContext, query)
+					if err != nil {
+						t.Errorf("ListResource(%s) error = %v", kubeContext, err)
+						return
+					}
+					if len(objects) != want {
+						t.Errorf("ListResource(%s) returned %d objects, want %d", kubeContext, len(objects), want)
+					}
+
+					table, err := ks.ListResourceTabular(t.Context(), kubeContext, query)
+					if err != nil {
+						t.Errorf("ListResourceTabular(%s) error = %v", kubeContext, err)
+						return
+					}
+					if len(table.Rows) != want {
+						t.Errorf("ListResourceTabular(%s) returned %d rows, want %d", kubeContext, len(table.Rows), want)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// countWatchers returns the number of watchers of a connection matching a predicate
+func countWatchers(conn *KubeConnection, match func(watcher Watcher) bool) int {
+	conn.watchersLock.Lock()
+	defer conn.watchersLock.Unlock()
+
+	count := 0
+	for _, watcher := range conn.watchers {
+		if match(watcher) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestWatchResourceStreamsWatchersUntilClosed(t *testing.T) {
+	ks := newTestKubeService(t, []string{"test"})
+	query := ResourceQuery{GVR: podsGVR, Namespaces: []string{"a", "b"}}
+
+	_, listSub, err := ks.WatchResource(t.Context(), "test", query)
+	if err != nil {
+		t.Fatalf("WatchResource() error = %v", err)
+	}
+	_, tableSub, err := ks.WatchResourceTabular(t.Context(), "test", query)
+	if err != nil {
+		t.Fatalf("WatchResourceTabular() error = %v", err)
+	}
+
+	conn := ks.connections["test"]
+	streamed := func(watcher Watcher) bool { return watcher.streamed() }
+
+	// Streams do not keep the watchers in use, they can still be evicted
+	if got := countWatchers(conn, func(watcher Watcher) bool { return watcher.inUse() }); got != 0 {
+		t.Fatalf("%d watchers in use while streamed, want none", got)
+	}
+	if got := countWatchers(conn, streamed); got != 4 {
+		t.Fatalf("%d watchers streamed while subscribed, want 4", got)
+	}
+
+	listSub.Close()
+	if got := countWatchers(conn, streamed); got != 2 {
+		t.Fatalf("%d watchers streamed after closing the list subscription, want 2", got)
+	}
+
+	tableSub.Close()
+	tableSub.Close()
+	if got := countWatchers(conn, streamed); got != 0 {
+		t.Fatalf("%d watchers streamed after closing both subscriptions, want 0", got)
+	}
+}
+
+func TestGetWatcherEvictsStreamedWatchersLast(t *testing.T) {
+	ks := newTestKubeService(t, []string{"test"})
+
+	_, sub, err := ks.WatchResource(t.Context(), "test", ResourceQuery{GVR: podsGVR, Namespaces: []string{"streamed"}})
+	if err != nil {
+		t.Fatalf("WatchResource() error = %v", err)
+	}
+	defer sub.Close()
+
+	// Idle watchers make room for each other while the streamed one is older
+	for i := range MaxWatchers {
+		if _, err := ks.ListResource(t.Context(), "test", ResourceQuery{GVR: podsGVR, Namespaces: []string{fmt.Sprintf("idle-%d", i)}}); err != nil {
+			t.Fatalf("ListResource() error = %v", err)
+		}
+	}
+	if sub.WatcherStopped() {
+		t.Fatal("the streamed watcher was evicted while idle watchers were left")
+	}
+
+	// Once every watcher is streamed, the least recently used one is evicted, ending its stream
+	for i := range MaxWatchers - 1 {
+		_, other, err := ks.WatchResource(t.Context(), "test", ResourceQuery{GVR: podsGVR, Namespaces: []string{fmt.Sprintf("other-%d", i)}})
+		if err != nil {
+			t.Fatalf("WatchResource() error = %v", err)
+		}
+		defer other.Close()
+	}
+	if sub.WatcherStopped() {
+		t.Fatal("the streamed watcher was evicted while idle watchers were left")
+	}
+
+	_, last, err := ks.WatchResource(t.Context(), "test", ResourceQuery{GVR: podsGVR, Namespaces: []string{"last"}})
+	if err != nil {
+		t.Fatalf("WatchResource() error = %v", err)
+	}
+	defer last.Close()
+
+	waitClosed(t, sub)
+	if !sub.WatcherStopped() {
+		t.Fatal("the subscription ended without its watcher being stopped")
+	}
+}
+
+func TestGetWatcherFailsWhenAllWatchersAreInUse(t *testing.T) {
+	ks := newTestKubeService(t, []string{"test"})
+
+	conn, err := ks.getConnection("test")
+	if err != nil {
+		t.Fatalf("getConnection() error = %v", err)
+	}
+	defer conn.release()
+
+	for i := range MaxWatchers {
+		watcher, err := conn.GetWatcher(WatcherConfig{GVR: podsGVR, Namespace: fmt.Sprintf("busy-%d", i)}, WatcherTypeList)
+		if err != nil {
+			t.Fatalf("GetWatcher() error = %v", err)
+		}
+		defer watcher.release()
+	}
+
+	_, err = conn.GetWatcher(WatcherConfig{GVR: podsGVR, Namespace: "over"}, WatcherTypeList)
+	if !errors.Is(err, ErrTooManyWatchers) {
+		t.Fatalf("GetWatcher() error = %v, want %v", err, ErrTooManyWatchers)
+	}
+	if got := len(conn.watchers); got != MaxWatchers {
+		t.Fatalf("%d watchers registered, want %d", got, MaxWatchers)
+	}
+}
+
+func TestGetWatcherNeverEvictsWatchersInUse(t *testing.T) {
+	ks := newTestKubeService(t, []string{"test"})
+
+	conn, err := ks.getConnection("test")
+	if err != nil {
+		t.Fatalf("getConnection() error = %v", err)
+	}
+	defer conn.release()
+
+	// The oldest watcher is held by a request while the budget fills up with idle ones
+	busy, err := conn.GetWatcher(WatcherConfig{GVR: podsGVR, Namespace: "busy"}, WatcherTypeList)
+	if err != nil {
+		t.Fatalf("GetWatcher() error = %v", err)
+	}
+	defer busy.release()
+
+	for i := range MaxWatchers {
+		watcher, err := conn.GetWatcher(WatcherConfig{GVR: podsGVR, Namespace: fmt.Sprintf("idle-%d", i)}, WatcherTypeList)
+		if err != nil {
+			t.Fatalf("GetWatcher() error = %v", err)
+		}
+		watcher.release()
+	}
+
+	conn.watchersLock.Lock()
+	defer conn.watchersLock.Unlock()
+
+	if _, ok := conn.watchers[busy.GetID()]; !ok {
+		t.Fatal("the watcher in use was evicted")
+	}
+	if _, ok := conn.watchers[FormatWatcherID(WatcherConfig{KubeContext: "test", GVR: podsGVR, Namespace: "idle-0"}, WatcherTypeList)]; ok {
+		t.Fatal("the least recently used idle watcher was not evicted")
+	}
+	if len(conn.watchers) != MaxWatchers {
+		t.Fatalf("%d watchers registered, want %d", len(conn.watchers), MaxWatchers)
+	}
+}
+
+func TestListResourcePageDoesNotRegisterWatchers(t *testing.T) {
+	ks := newTestKubeService(t, []string{"test"})
+	query := ResourceQuery{GVR: podsGVR, Namespaces: []string{"a", "b"}}
+	want := len(query.Namespaces) * testPodsPerNamespace
+
+	objects, _, err := ks.ListResourcePage(t.Context(), "test", query, int64(want), "")
+	if err != nil {
+		t.Fatalf("ListResourcePage() error = %v", err)
+	}
+	if len(objects) != want {
+		t.Fatalf("ListResourcePage() returned %d objects, want %d", len(objects), want)
+	}
+
+	table, _, err := ks.ListResourceTabularPage(t.Context(), "test", query, int64(want), "")
+	if err != nil {
+		t.Fatalf("ListResourceTabularPage() error = %v", err)
+	}
+	if len(table.Rows) != want {
+		t.Fatalf("ListResourceTabularPage() returned %d rows, want %d", len(table.Rows), want)
+	}
+
+	if watchers := len(ks.connections["test"].watchers); watchers != 0 {
+		t.Fatalf("paging registered %d watchers, want none", watchers)
+	}
+}
@@ -10,20 +10,63 @@ import (
 	"github.com/rneacsu/spyglass/internal/logger"
 	metainternalversionscheme "k8s.io/apimachinery/pkg/apis/meta/internalversion/scheme"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 )
 
 type TableWatcher struct {
 	*baseWatcher
 
-	client    *rest.RESTClient
-	tableLock sync.RWMutex
-	table     metav1.Table
+	lister      *tableLister
+	tableLock   sync.RWMutex
+	table       metav1.Table
+	subscribers *broadcaster[TableEvent]
+
+	// customColumns returns the evaluator of the custom columns of the resource, or nil. The rows are
+	// evaluated once, when listed or changed, with the evaluator of the last list.
+	customColumns func() *columnEvaluator
+	evaluator     *columnEvaluator
+
+	// printer builds the rows of the last list from full objects, nil when they come from the server. The
+	// watch follows the same printer until the next list.
+	printer *tablePrinter
+}
+
+// tableLister fetches pages of the table of a resource straight from the API server
+type tableLister struct {
+	client  *rest.RESTClient
+	dynamic dynamic.Interface
+	config  WatcherConfig
+
+	// printers caches the printers building the rows from full objects when the server does not provide
+	// them, resolved on the first page listed
+	printers *printerCache
 }
 
-func NewTableWatcher(clientConfig *rest.Config, config WatcherConfig) (*TableWatcher, error) {
+func NewTableWatcher(clientConfig *rest.Config, config WatcherConfig, printers *printerCache, customColumns func() *columnEvaluator) (*TableWatcher, error) {
+	lister, err := newTableLister(clientConfig, config, printers)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTableWatcher(lister, config, customColumns), nil
+}
+
+func newTableWatcher(lister *tableLister, config WatcherConfig, customColumns func() *columnEvaluator) *TableWatcher {
+	return &TableWatcher{
+		baseWatcher:   NewBaseWatcher(config, WatcherTypeTable),
+		lister:        lister,
+		subscribers:   newBroadcaster[TableEvent](),
+		customColumns: customColumns,
+	}
+}
+
+func newTableLister(clientConfig *rest.Config, config WatcherConfig, printers *printerCache) (*tableLister, error) {
 	restConfig := rest.CopyConfig(clientConfig)
 	restConfig.AcceptContentTypes = "application/json;as=Table;v=v1;g=meta.k8s.io,application/json"
 	restConfig.ContentType = "application/json"
@@ -48,9 +91,16 @@ func NewTableWatcher(clientConfig *rest.Config, config WatcherConfig) (*TableWat
 		return nil, err
 	}
 
-	return &TableWatcher{
-		baseWatcher: NewBaseWatcher(config, WatcherTypeTable),
-		client:      restClient,
+	dynamicClient, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableLister{
+		client:   restClient,
+		dynamic:  dynamicClient,
+		config:   config,
+		printers: printers,
 	}, nil
 }
 
@@ -66,120 +116,358 @@ func decodeTableRows(table *metav1.Table) error {
 	return nil
 }
 
+// TableEvent is a change to a single row of a watched table
+type TableEvent struct {
+	Type watch.EventType
+	Row  metav1.TableRow
+}
+
 func (tw *TableWatcher) GetTable(ctx context.Context) (*metav1.Table, error) {
 	tw.watchLock.Lock()
 	defer tw.watchLock.Unlock()
 
-	if tw.watch == nil {
-		// Start by listing the resources
-		listOpt := metav1.ListOptions{}
+	if err := tw.ensureWatch(ctx); err != nil {
+		return nil, err
+	}
+
+	tw.tableLock.RLock()
+	defer tw.tableLock.RUnlock()
+
+	return tw.snapshot(), nil
+}
+
+// Watch returns the current table together with a subscription to every subsequent row change.
+// The subscription must be closed by the caller.
+func (tw *TableWatcher) Watch(ctx context.Context) (*metav1.Table, *Subscription[TableEvent], error) {
+	tw.watchLock.Lock()
+	defer tw.watchLock.Unlock()
+
+	if err := tw.ensureWatch(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// Take the snapshot and subscribe under the same lock so no event is missed in between
+	tw.tableLock.RLock()
+	defer tw.tableLock.RUnlock()
+
+	return tw.snapshot(), newSubscription(tw.baseWatcher, tw.subscribers), nil
+}
+
+func (tw *TableWatcher) ensureWatch(ctx context.Context) error {
+	return tw.baseWatcher.ensureWatch(ctx, tw)
+}
+
+// RefreshCustomColumns relists the table, so the rows follow the current custom columns of the resource
+func (tw *TableWatcher) RefreshCustomColumns() {
+	tw.relist()
+}
+
+// ListPage fetches a single page of the table. The rows are built locally from the printer columns of the
+// custom resource definition when the server does not support tables for the resource, or only returns the
+// name and age columns. The custom columns of the evaluator, if any, are appended.
+func (tl *tableLister) ListPage(ctx context.Context, limit int64, continueToken string, evaluator *columnEvaluator) (*metav1.Table, error) {
+	table, _, err := tl.listPage(ctx, limit, continueToken, evaluator)
+	return table, err
+}
+
+// listPage is ListPage, also returning the printer that built the rows, or nil when they come from the server
+func (tl *tableLister) listPage(ctx context.Context, limit int64, continueToken string, evaluator *columnEvaluator) (*metav1.Table, *tablePrinter, error) {
+	listOpt := metav1.ListOptions{
+		LabelSelector: tl.config.LabelSelector,
+		FieldSelector: tl.config.FieldSelector,
+		Limit:         limit,
+		Continue:      continueToken,
+	}
+
+	if printer, ok := tl.printers.get(tl.config.GVR); ok && printer != nil {
+		table, err := tl.listPageLocally(ctx, printer, listOpt, evaluator)
+		return table, printer, err
+	}
+
+	listRequest := tl.client.Get()
+	if tl.config.Namespace != "" {
+		listRequest = listRequest.Namespace(tl.config.Namespace)
+	}
+	listRequest = listRequest.Resource(tl.config.GVR.Resource).SpecificallyVersionedParams(&listOpt, metav1.ParameterCodec, metav1.Unversioned)
+	if evaluator != nil {
+		listRequest = listRequest.Param("includeObject", string(metav1.IncludeObject))
+	}
+
+	logger.Info(listRequest.URL().String())
+
+	result := listRequest.Do(ctx)
+	raw, err := result.Raw()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list (context: %s, resource: %s, namespace: %s): %w", tl.config.KubeContext, tl.config.GVR, tl.config.Namespace, err)
+	}
+
+	// Aggregated APIs may not support tables and answer with a plain list
+	var typeMeta metav1.TypeMeta
+	if err = json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode list: %w", err)
+	}
+	supportsTable := typeMeta.Kind == "Table"
+
+	listResult := &metav1.Table{}
+	if supportsTable {
+		if err = result.Into(listResult); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode table: %w", err)
+		}
+	}
+
+	if !supportsTable || isMinimalTable(listResult) {
+		printer, err := tl.resolvePrinter(ctx, supportsTable)
+		if err != nil {
+			return nil, nil, err
+		}
+		if printer != nil {
+			table, err := tl.listPageLocally(ctx, printer, listOpt, evaluator)
+			return table, printer, err
+		}
+	}
+
+	if err = decodeTableRows(listResult); err != nil {
+		return nil, nil, err
+	}
+
+	return evaluator.table(listResult), nil, nil
+}
 
-		listRequest := tw.client.Get()
-		if tw.config.Namespace != "" {
-			listRequest = listRequest.Namespace(tw.config.Namespace)
+// listPageLocally lists a page of full objects and builds their rows
+func (tl *tableLister) listPageLocally(ctx context.Context, printer *tablePrinter, listOpt metav1.ListOptions, evaluator *columnEvaluator) (*metav1.Table, error) {
+	listResult, err := resourceClient(tl.dynamic, tl.config).List(ctx, listOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list (context: %s, resource: %s, namespace: %s): %w", tl.config.KubeContext, tl.config.GVR, tl.config.Namespace, err)
+	}
+
+	table := printer.table(listResult)
+	if evaluator != nil {
+		table.ColumnDefinitions = evaluator.columnDefinitions(table.ColumnDefinitions)
+		for i := range table.Rows {
+			table.Rows[i] = evaluator.objectRow(table.Rows[i], listResult.Items[i].Object)
 		}
-		listRequest = listRequest.Resource(tw.config.GVR.Resource).SpecificallyVersionedParams(&listOpt, metav1.ParameterCodec, metav1.Unversioned)
+	}
 
-		logger.Info(listRequest.URL().String())
+	return table, nil
+}
+
+// resolvePrinter returns the printer building the rows locally, or nil when the table of the server is to
+// be used. Rows are built locally when the server does not support tables, or when the custom resource
+// definition has more columns than the minimal table of the server.
+func (tl *tableLister) resolvePrinter(ctx context.Context, supportsTable bool) (*tablePrinter, error) {
+	if printer, ok := tl.printers.get(tl.config.GVR); ok {
+		return printer, nil
+	}
+
+	columns, err := crdPrinterColumns(ctx, tl.dynamic, tl.config.GVR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get printer columns (context: %s, resource: %s): %w", tl.config.KubeContext, tl.config.GVR, err)
+	}
 
-		listResult := metav1.Table{}
-		err := listRequest.Do(ctx).Into(&listResult)
+	if supportsTable && !hasExtraColumns(columns) {
+		tl.printers.set(tl.config.GVR, nil)
+		return nil, nil
+	}
+	if columns == nil {
+		columns = defaultPrinterColumns
+	}
 
+	printer, err := newTablePrinter(columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build table (context: %s, resource: %s): %w", tl.config.KubeContext, tl.config.GVR, err)
+	}
+	logger.Infow("building table rows from printer columns", "context", tl.config.KubeContext, "resource", tl.config.GVR)
+	tl.printers.set(tl.config.GVR, printer)
+
+	return printer, nil
+}
+
+func (tw *TableWatcher) list(ctx context.Context) (string, error) {
+	var table metav1.Table
+	var printer *tablePrinter
+	evaluator := tw.customColumns()
+
+	// List in chunks to keep the size of each response bounded on large clusters
+	limit := int64(DefaultListPageSize)
+	continueToken := ""
+	for {
+		listResult, pagePrinter, err := tw.lister.listPage(ctx, limit, continueToken, evaluator)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list (context: %s, resource: %s, namespace: %s): %w", tw.config.KubeContext, tw.config.GVR, tw.config.Namespace, err)
+			if continueToken != "" && isExpiredError(err) {
+				// The snapshot behind the continue token was compacted, fall back to a full list
+				table = metav1.Table{}
+				limit = 0
+				continueToken = ""
+				continue
+			}
+			return "", err
 		}
 
-		// Then start a background watch
-		timeout := int64(DefaultWatchTimeout.Seconds())
-		watchOpts := metav1.ListOptions{
-			Watch:           true,
-			ResourceVersion: listResult.GetResourceVersion(),
-			TimeoutSeconds:  &timeout,
+		// Every chunk is served from the snapshot of the first one
+		if continueToken == "" {
+			table.ColumnDefinitions = listResult.ColumnDefinitions
+			table.ResourceVersion = listResult.ResourceVersion
+			printer = pagePrinter
 		}
+		table.Rows = append(table.Rows, listResult.Rows...)
 
-		watchRequest := tw.client.Get()
-		if tw.config.Namespace != "" {
-			watchRequest = watchRequest.Namespace(tw.config.Namespace)
+		continueToken = listResult.Continue
+		if continueToken == "" {
+			break
 		}
+	}
 
-		watcher, err := watchRequest.Resource(tw.config.GVR.Resource).SpecificallyVersionedParams(&watchOpts, metav1.ParameterCodec, metav1.Unversioned).Watch(context.Background())
+	// Replace the whole table and resync subscribers under the same lock so none of them mixes both states
+	tw.tableLock.Lock()
+	tw.table = table
+	tw.evaluator = evaluator
+	tw.printer = printer
+	tw.subscribers.closeAll()
+	tw.tableLock.Unlock()
+
+	return table.ResourceVersion, nil
+}
+
+func (tw *TableWatcher) startWatch(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	timeout := int64(DefaultWatchTimeout.Seconds())
+	watchOpts := metav1.ListOptions{
+		LabelSelector:       tw.config.LabelSelector,
+		FieldSelector:       tw.config.FieldSelector,
+		Watch:               true,
+		ResourceVersion:     resourceVersion,
+		TimeoutSeconds:      &timeout,
+		AllowWatchBookmarks: true,
+	}
+
+	// The watch carries the full objects only as long as the rows of the last list did
+	tw.tableLock.RLock()
+	includeObject := tw.evaluator != nil
+	printer := tw.printer
+	tw.tableLock.RUnlock()
+
+	if printer != nil {
+		watcher, err := resourceClient(tw.lister.dynamic, tw.config).Watch(ctx, watchOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to watch (context: %s, resource: %s, namespace: %s): %w", tw.config.KubeContext, tw.config.Namespace, tw.config.GVR, err)
+			return nil, fmt.Errorf("failed to watch (context: %s, resource: %s, namespace: %s): %w", tw.config.KubeContext, tw.config.GVR, tw.config.Namespace, err)
+		}
+		return watcher, nil
+	}
+
+	watchRequest := tw.lister.client.Get()
+	if tw.config.Namespace != "" {
+		watchRequest = watchRequest.Namespace(tw.config.Namespace)
+	}
+
+	watchRequest = watchRequest.Resource(tw.config.GVR.Resource).SpecificallyVersionedParams(&watchOpts, metav1.ParameterCodec, metav1.Unversioned)
+	if includeObject {
+		watchRequest = watchRequest.Param("includeObject", string(metav1.IncludeObject))
+	}
+
+	watcher, err := watchRequest.Watch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch (context: %s, resource: %s, namespace: %s): %w", tw.config.KubeContext, tw.config.GVR, tw.config.Namespace, err)
+	}
+
+	return watcher, nil
+}
+
+func (tw *TableWatcher) handleEvent(event watch.Event) {
+	tw.tableLock.Lock()
+	defer tw.tableLock.Unlock()
+
+	switch event.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		tableRow, ok := tw.eventRow(event.Object)
+		if !ok {
+			return
+		}
+		objUID := tableRow.Object.Object.(*metav1.PartialObjectMetadata).UID
+
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			// A resumed watch may replay the addition of a known object, and a modification may arrive for
+			// an object missed in between, keep a single row per object either way
+			if i := tw.rowIndex(objUID); i >= 0 {
+				tw.table.Rows[i] = tableRow
+			} else {
+				tw.table.Rows = append(tw.table.Rows, tableRow)
+			}
+		case watch.Deleted:
+			i := tw.rowIndex(objUID)
+			if i < 0 {
+				// Already removed when deleted through the connection
+				return
+			}
+			tw.table.Rows = append(tw.table.Rows[:i], tw.table.Rows[i+1:]...)
 		}
 
-		tw.watch = watcher
+		tw.subscribers.publish(TableEvent{
+			Type: event.Type,
+			Row:  tableRow,
+		})
 
-		// Add the initial table
-		tw.tableLock.Lock()
-		tw.table = listResult
-		if err = decodeTableRows(&tw.table); err != nil {
-			return nil, err
+	case watch.Error:
+		logger.Errorw(watchErrorMessage(event), tw.logContext...)
+	}
+}
+
+// eventRow returns the row of the object of a watch event, a table from the server or a full object when
+// the rows are built locally, with the custom columns evaluated. Must be called with tableLock held.
+func (tw *TableWatcher) eventRow(obj runtime.Object) (metav1.TableRow, bool) {
+	switch typed := obj.(type) {
+	case *metav1.Table:
+		if err := decodeTableRows(typed); err != nil {
+			logger.Errorw(fmt.Sprintf("failed to decode table rows: %v", err), tw.logContext...)
+			return metav1.TableRow{}, false
+		}
+		if len(typed.Rows) == 0 {
+			return metav1.TableRow{}, false
 		}
+		return tw.evaluator.row(typed.Rows[0]), true
+	case *unstructured.Unstructured:
+		if tw.printer != nil {
+			return tw.evaluator.objectRow(tw.printer.row(typed), typed.Object), true
+		}
+	}
 
-		tw.tableLock.Unlock()
-
-		tw.watchWG.Add(1)
-		go func() {
-			defer tw.watchWG.Done()
-			logger.Infow("background watching started", tw.logContext...)
-
-			for event := range watcher.ResultChan() {
-				tw.tableLock.Lock()
-
-				switch event.Type {
-				case watch.Added, watch.Modified, watch.Deleted:
-					table := event.Object.(*metav1.Table)
-					if err = decodeTableRows(table); err != nil {
-						logger.Errorw(fmt.Sprintf("failed to decode table rows: %v", err), tw.logContext...)
-						tw.tableLock.Unlock()
-						continue
-					}
-					tableRow := table.Rows[0]
-					objUID := tableRow.Object.Object.(*metav1.PartialObjectMetadata).UID
-
-					switch event.Type {
-					case watch.Added:
-						tw.table.Rows = append(tw.table.Rows, tableRow)
-					case watch.Modified, watch.Deleted:
-						for i := range tw.table.Rows {
-							curObjId := tw.table.Rows[i].Object.Object.(*metav1.PartialObjectMetadata).UID
-
-							if curObjId == objUID {
-								switch event.Type {
-								case watch.Modified:
-									tw.table.Rows[i] = tableRow
-								case watch.Deleted:
-									tw.table.Rows = append(tw.table.Rows[:i], tw.table.Rows[i+1:]...)
-								}
-								break
-							}
-						}
-					}
-
-				case watch.Error:
-					var logMsg string
-					if status, ok := event.Object.(*metav1.Status); ok {
-						logMsg = fmt.Sprintf("watch event error: %s, reason: %s", status.Message, status.Reason)
-					} else {
-						logMsg = fmt.Sprintf("watch event error: %v", event.Object)
-					}
-					logger.Errorw(logMsg, tw.logContext...)
-				}
-
-				tw.tableLock.Unlock()
-			}
+	logger.Errorw(fmt.Sprintf("unexpected watch event object: %T", obj), tw.logContext...)
+	return metav1.TableRow{}, false
+}
+
+// rowIndex returns the index of the row of an object, or -1. Must be called with tableLock held.
+func (tw *TableWatcher) rowIndex(uid types.UID) int {
+	for i := range tw.table.Rows {
+		if tw.table.Rows[i].Object.Object.(*metav1.PartialObjectMetadata).UID == uid {
+			return i
+		}
+	}
+	return -1
+}
 
-			tw.watchLock.Lock()
-			defer tw.watchLock.Unlock()
-			tw.watch = nil
+func (tw *TableWatcher) objectDeleted(uid types.UID) {
+	tw.tableLock.Lock()
+	defer tw.tableLock.Unlock()
 
-			logger.Infow("background watching finished", tw.logContext...)
-		}()
+	i := tw.rowIndex(uid)
+	if i < 0 {
+		return
 	}
+	row := tw.table.Rows[i]
+	tw.table.Rows = append(tw.table.Rows[:i], tw.table.Rows[i+1:]...)
 
-	tw.tableLock.RLock()
-	defer tw.tableLock.RUnlock()
+	tw.subscribers.publish(TableEvent{
+		Type: watch.Deleted,
+		Row:  row,
+	})
+}
+
+func (tw *TableWatcher) resync() {
+	tw.subscribers.closeAll()
+}
 
+// snapshot returns a sorted copy of the current table. Must be called with tableLock held.
+func (tw *TableWatcher) snapshot() *metav1.Table {
 	tableResult := &metav1.Table{
 		ColumnDefinitions: tw.table.ColumnDefinitions,
 		Rows:              make([]metav1.TableRow, len(tw.table.Rows)),
@@ -193,5 +481,5 @@ func (tw *TableWatcher) GetTable(ctx context.Context) (*metav1.Table, error) {
 		return aName < bName
 	})
 
-	return tableResult, nil
+	return tableResult
 }
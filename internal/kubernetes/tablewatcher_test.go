@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func newTestPodOnNode(name string, node string) *unstructured.Unstructured {
+	pod := newTestPod(name)
+	if err := unstructured.SetNestedField(pod.Object, node, "spec", "nodeName"); err != nil {
+		panic(err)
+	}
+	return pod
+}
+
+func TestTableWatcherEvaluatesCustomColumns(t *testing.T) {
+	client := newFakeDynamicClient(newTestPodOnNode("a", "node-1"))
+	watches := newControlledWatches(client)
+
+	printer, err := newTablePrinter(defaultPrinterColumns)
+	if err != nil {
+		t.Fatalf("newTablePrinter() error = %v", err)
+	}
+	evaluator, err := compileColumns(podsGVR, []CustomColumn{{Name: "Node", JSONPath: ".spec.nodeName"}})
+	if err != nil {
+		t.Fatalf("compileColumns() error = %v", err)
+	}
+
+	var columns atomic.Pointer[columnEvaluator]
+	config := WatcherConfig{KubeContext: "test", GVR: podsGVR, Namespace: "default"}
+	printers := newPrinterCache()
+	printers.set(config.GVR, printer)
+	tw := newTableWatcher(&tableLister{dynamic: client, config: config, printers: printers}, config, columns.Load)
+	defer tw.Stop()
+
+	table, sub, err := tw.Watch(t.Context())
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer sub.Close()
+	waitFor(t, watches.started, "the first watch")
+	if got := len(table.ColumnDefinitions); got != 2 {
+		t.Fatalf("Watch() returned %d columns without custom columns, want 2", got)
+	}
+
+	// Setting custom columns relists the table with them
+	columns.Store(evaluator)
+	tw.RefreshCustomColumns()
+	waitClosed(t, sub)
+	waitFor(t, watches.started, "the watch to be resumed")
+
+	table, resub, err := tw.Watch(t.Context())
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer resub.Close()
+	if got := table.ColumnDefinitions[len(table.ColumnDefinitions)-1].Name; got != "Node" {
+		t.Fatalf("last column = %s, want Node", got)
+	}
+	if got := table.Rows[0].Cells[len(table.Rows[0].Cells)-1]; got != "node-1" {
+		t.Fatalf("Node cell = %v, want node-1", got)
+	}
+
+	// Changed rows are evaluated as their events are handled
+	watches.lock.Lock()
+	w := watches.watches[len(watches.watches)-1]
+	watches.lock.Unlock()
+	w.Modify(newTestPodOnNode("a", "node-2"))
+
+	select {
+	case event := <-resub.Events:
+		if event.Type != watch.Modified {
+			t.Fatalf("event type = %s, want %s", event.Type, watch.Modified)
+		}
+		if got := event.Row.Cells[len(event.Row.Cells)-1]; got != "node-2" {
+			t.Fatalf("Node cell = %v, want node-2", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the modified row")
+	}
+}
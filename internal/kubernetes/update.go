@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ConflictError is returned when a resource was changed since the version an update was based on. It
+// carries the live object, unwrapping to the Conflict status of the API server.
+type ConflictError struct {
+	Err  error
+	Live *unstructured.Unstructured
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// decodeManifests decodes the objects of a YAML or JSON manifest, YAML allowing multiple documents.
+// Empty documents are skipped.
+func decodeManifests(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	objects := make([]*unstructured.Unstructured, 0, 1)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid manifest: %v", err))
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" {
+			return nil, apierrors.NewBadRequest("invalid manifest: apiVersion and kind are required")
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// UpdateResource replaces a resource with the object of a YAML or JSON manifest. The object is validated
+// with a server-side dry-run first, and must carry the resource version it was edited from: if the resource
+// changed since, a ConflictError with the live object is returned. Namespaced objects without a namespace
+// default to the namespace of the context.
+func (kc *KubeConnection) UpdateResource(ctx context.Context, gvr schema.GroupVersionResource, manifest []byte, dryRun bool) (*unstructured.Unstructured, error) {
+	kc.UpdateLastUsed()
+
+	objects, err := decodeManifests(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) != 1 {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a single object, manifest contains %d", len(objects)))
+	}
+
+	obj := objects[0]
+	if obj.GroupVersionKind().GroupVersion() != gvr.GroupVersion() {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("apiVersion %s does not match resource %s", obj.GetAPIVersion(), gvr.String()))
+	}
+	if obj.GetName() == "" {
+		return nil, apierrors.NewBadRequest("metadata.name is required")
+	}
+	if obj.GetResourceVersion() == "" {
+		return nil, apierrors.NewBadRequest("metadata.resourceVersion is required to detect conflicting changes")
+	}
+
+	// The scope decides the namespace rather than the manifest, which may omit it like apply allows
+	mapping, err := kc.restMapping(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Resource != gvr {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("kind %s does not match resource %s", obj.GetKind(), gvr.String()))
+	}
+
+	namespace := ""
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(kc.defaultNamespace)
+		}
+		namespace = obj.GetNamespace()
+	}
+
+	client := resourceClient(kc.dynamic, WatcherConfig{GVR: gvr, Namespace: namespace})
+
+	opts := metav1.UpdateOptions{
+		DryRun:          []string{metav1.DryRunAll},
+		FieldValidation: metav1.FieldValidationStrict,
+	}
+	updated, err := client.Update(ctx, obj, opts)
+	if err == nil && !dryRun {
+		opts.DryRun = nil
+		updated, err = client.Update(ctx, obj, opts)
+	}
+
+	if apierrors.IsConflict(err) {
+		live, getErr := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if getErr != nil {
+			return nil, err
+		}
+		return nil, &ConflictError{Err: err, Live: live}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
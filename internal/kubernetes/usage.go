@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// usageTracker records when an object was last used, how many in-flight requests are using it, so that
+// LRU eviction never stops something a request is still working with, and how many streams follow it
+type usageTracker struct {
+	lastUsed atomic.Int64
+	inFlight atomic.Int32
+	streams  atomic.Int32
+}
+
+func (u *usageTracker) GetLastUsed() time.Time {
+	return time.Unix(0, u.lastUsed.Load())
+}
+
+func (u *usageTracker) UpdateLastUsed() {
+	u.lastUsed.Store(time.Now().UnixNano())
+}
+
+// acquire marks the start of a request. Must be called while holding the lock of the map the object
+// is evicted from, so the object cannot be evicted in between
+func (u *usageTracker) acquire() {
+	u.UpdateLastUsed()
+	u.inFlight.Add(1)
+}
+
+// release marks the end of a request started with acquire
+func (u *usageTracker) release() {
+	u.inFlight.Add(-1)
+}
+
+func (u *usageTracker) inUse() bool {
+	return u.inFlight.Load() > 0
+}
+
+// addStream marks the start of a stream following the object. Unlike requests, streams do not protect the
+// object from eviction, which ends them, but objects without streams are evicted first.
+// Must be called while the object is acquired, so it cannot be evicted in between.
+func (u *usageTracker) addStream() {
+	u.streams.Add(1)
+}
+
+// removeStream marks the end of a stream started with addStream
+func (u *usageTracker) removeStream() {
+	u.UpdateLastUsed()
+	u.streams.Add(-1)
+}
+
+func (u *usageTracker) streamed() bool {
+	return u.streams.Load() > 0
+}
@@ -1,16 +1,37 @@
 package kubernetes
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rneacsu/spyglass/internal/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 )
 
 const (
 	DefaultWatchTimeout = 120 * time.Second
+
+	// DefaultWatchRetries is the number of attempts made to resume a watch before giving up
+	DefaultWatchRetries = 3
+
+	// DefaultWatchRetryDelay is the initial delay between attempts to resume a watch
+	DefaultWatchRetryDelay = 1 * time.Second
+
+	// DefaultListPageSize is the number of objects requested per chunk when listing
+	DefaultListPageSize = 500
+
+	// DefaultSubscriberBuffer is the number of events buffered for a subscriber before it is dropped
+	DefaultSubscriberBuffer = 256
 )
 
 type Watcher interface {
@@ -19,6 +40,15 @@ type Watcher interface {
 	UpdateLastUsed()
 	GetType() WatcherType
 	GetID() string
+
+	acquire()
+	release()
+	inUse() bool
+	addStream()
+	removeStream()
+	streamed() bool
+	// objectDeleted drops an object deleted through the connection from the cache, ahead of its watch event
+	objectDeleted(uid types.UID)
 }
 
 type WatcherType string
@@ -33,43 +63,66 @@ type WatcherConfig struct {
 	KubeContext string
 	GVR         schema.GroupVersionResource
 	Namespace   string
+	// Name restricts the watcher to a single object, only used by resource watchers
+	Name          string
+	LabelSelector string
+	FieldSelector string
 
 	watcherType WatcherType
 }
 
+// watchSource is implemented by every watcher type to feed the background watch loop
+type watchSource interface {
+	// list replaces the cache with the current state, resyncing subscribers, and returns the resource version to watch from
+	list(ctx context.Context) (string, error)
+	// startWatch starts a watch from the given resource version
+	startWatch(ctx context.Context, resourceVersion string) (watch.Interface, error)
+	// handleEvent applies a watch event to the cache
+	handleEvent(event watch.Event)
+	// resync drops all subscribers, forcing them to start over from a fresh snapshot
+	resync()
+}
+
 type baseWatcher struct {
+	usageTracker
+
 	config     WatcherConfig
 	watch      watch.Interface
+	running    bool
 	watchLock  sync.Mutex
 	watchWG    sync.WaitGroup
 	logContext []interface{}
+	ctx        context.Context
+	cancel     context.CancelFunc
 
-	LastUsed time.Time
+	// relistRequested makes the background watch start over from a fresh list once it ends
+	relistRequested atomic.Bool
 }
 
 func NewBaseWatcher(config WatcherConfig, watcherType WatcherType) *baseWatcher {
 	config.watcherType = watcherType
-	return &baseWatcher{
-		config:   config,
-		LastUsed: time.Now(),
+	ctx, cancel := context.WithCancel(context.Background())
+	bw := &baseWatcher{
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
 		logContext: []interface{}{
 			"context", config.KubeContext,
 			"resource", config.GVR,
+			"namespace", config.Namespace,
+			"name", config.Name,
+			"labelSelector", config.LabelSelector,
+			"fieldSelector", config.FieldSelector,
 			"type", watcherType,
 		},
 	}
-}
-
-func (bw *baseWatcher) GetLastUsed() time.Time {
-	return bw.LastUsed
-}
-
-func (bw *baseWatcher) UpdateLastUsed() {
-	bw.LastUsed = time.Now()
+	bw.UpdateLastUsed()
+	return bw
 }
 
 func (bw *baseWatcher) Stop() {
 	bw.watchLock.Lock()
+	bw.cancel()
 	if bw.watch != nil {
 		bw.watch.Stop()
 	}
@@ -78,14 +131,388 @@ func (bw *baseWatcher) Stop() {
 	logger.Infow("Stopped watching", bw.logContext...)
 }
 
+func (bw *baseWatcher) isStopped() bool {
+	return bw.ctx.Err() != nil
+}
+
+// ensureWatch lists the resources and starts the background watch loop if it is not running.
+// Must be called with watchLock held.
+func (bw *baseWatcher) ensureWatch(ctx context.Context, src watchSource) error {
+	if bw.running {
+		return nil
+	}
+	if bw.isStopped() {
+		return fmt.Errorf("watcher stopped (context: %s, resource: %s)", bw.config.KubeContext, bw.config.GVR)
+	}
+
+	resourceVersion, err := src.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := src.startWatch(bw.ctx, resourceVersion)
+	if err != nil {
+		return err
+	}
+
+	bw.watch = watcher
+	bw.running = true
+
+	bw.watchWG.Add(1)
+	go bw.run(src, watcher, resourceVersion)
+
+	return nil
+}
+
+// run consumes the background watch until the watcher is stopped. When the watch ends it is resumed
+// from the last seen resource version, falling back to a relist when that version has expired.
+func (bw *baseWatcher) run(src watchSource, watcher watch.Interface, resourceVersion string) {
+	defer bw.watchWG.Done()
+	logger.Infow("background watching started", bw.logContext...)
+
+	defer func() {
+		bw.watchLock.Lock()
+		defer bw.watchLock.Unlock()
+		bw.watch = nil
+		bw.running = false
+
+		// Subscribers have to resubscribe to get a fresh snapshot
+		src.resync()
+
+		logger.Infow("background watching finished", bw.logContext...)
+	}()
+
+	for {
+		started := time.Now()
+		expired := false
+
+		for event := range watcher.ResultChan() {
+			if event.Type == watch.Error {
+				if isExpiredError(apierrors.FromObject(event.Object)) {
+					expired = true
+				}
+			} else if rv := objectResourceVersion(event.Object); rv != "" {
+				resourceVersion = rv
+			}
+			src.handleEvent(event)
+		}
+
+		if bw.isStopped() {
+			return
+		}
+
+		relist := bw.relistRequested.Swap(false)
+
+		// Avoid hammering the API server with watches that end right away
+		if !relist && time.Since(started) < DefaultWatchRetryDelay && !bw.sleep(DefaultWatchRetryDelay) {
+			return
+		}
+
+		var err error
+		watcher, resourceVersion, err = bw.restart(src, resourceVersion, expired || relist)
+		if err != nil {
+			if !bw.isStopped() {
+				logger.Errorw(fmt.Sprintf("failed to resume watch: %v", err), bw.logContext...)
+			}
+			return
+		}
+
+		bw.watchLock.Lock()
+		if bw.isStopped() {
+			bw.watchLock.Unlock()
+			watcher.Stop()
+			return
+		}
+		bw.watch = watcher
+		if bw.relistRequested.Load() {
+			// Requested while resuming, the resumed watch still follows the previous list
+			watcher.Stop()
+		}
+		bw.watchLock.Unlock()
+	}
+}
+
+// relist ends the background watch, making it start over from a fresh list which resyncs the subscribers.
+// Nothing is done when not watching, the next watch starting from a fresh list anyway.
+func (bw *baseWatcher) relist() {
+	bw.watchLock.Lock()
+	defer bw.watchLock.Unlock()
+
+	if bw.watch == nil {
+		return
+	}
+	bw.relistRequested.Store(true)
+	bw.watch.Stop()
+}
+
+// restart starts a new watch from the given resource version, relisting first when it has expired
+func (bw *baseWatcher) restart(src watchSource, resourceVersion string, expired bool) (watch.Interface, string, error) {
+	var err error
+	delay := DefaultWatchRetryDelay
+
+	for attempt := 0; attempt < DefaultWatchRetries; attempt++ {
+		if attempt > 0 {
+			if !bw.sleep(delay) {
+				return nil, "", bw.ctx.Err()
+			}
+			delay *= 2
+		}
+
+		if expired {
+			logger.Infow("watch expired, relisting", bw.logContext...)
+
+			var rv string
+			rv, err = src.list(bw.ctx)
+			if err != nil {
+				continue
+			}
+			resourceVersion = rv
+			expired = false
+		}
+
+		var watcher watch.Interface
+		watcher, err = src.startWatch(bw.ctx, resourceVersion)
+		if err == nil {
+			logger.Debugw("background watch resumed", append(bw.logContext, "resourceVersion", resourceVersion)...)
+			return watcher, resourceVersion, nil
+		}
+
+		if isExpiredError(err) {
+			expired = true
+		}
+	}
+
+	return nil, "", err
+}
+
+// sleep waits for the given duration and returns false if the watcher was stopped in the meantime
+func (bw *baseWatcher) sleep(d time.Duration) bool {
+	select {
+	case <-bw.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// resourceClient returns a dynamic client scoped to the namespace of the watcher, if any
+func resourceClient(client dynamic.Interface, config WatcherConfig) dynamic.ResourceInterface {
+	if config.Namespace != "" {
+		return client.Resource(config.GVR).Namespace(config.Namespace)
+	}
+	return client.Resource(config.GVR)
+}
+
+func isExpiredError(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
+
+// objectResourceVersion returns the resource version carried by a watch event object
+func objectResourceVersion(obj runtime.Object) string {
+	if table, ok := obj.(*metav1.Table); ok {
+		if len(table.Rows) > 0 {
+			if pom, ok := table.Rows[0].Object.Object.(*metav1.PartialObjectMetadata); ok {
+				return pom.ResourceVersion
+			}
+		}
+		return table.ResourceVersion
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetResourceVersion()
+}
+
+// watchErrorMessage formats a watch error event for logging
+func watchErrorMessage(event watch.Event) string {
+	if status, ok := event.Object.(*metav1.Status); ok {
+		return fmt.Sprintf("watch event error: %s, reason: %s", status.Message, status.Reason)
+	}
+	return fmt.Sprintf("watch event error: %v", event.Object)
+}
+
 func (bw *baseWatcher) GetType() WatcherType {
 	return bw.config.watcherType
 }
 
 func (bw *baseWatcher) GetID() string {
-	return FormatWatcherID(bw.config.GVR, bw.config.Namespace, bw.config.watcherType)
+	return FormatWatcherID(bw.config, bw.config.watcherType)
+}
+
+func FormatWatcherID(config WatcherConfig, watcherType WatcherType) string {
+	return config.GVR.String() + "#" + config.Namespace + "#" + config.Name + "#" + config.LabelSelector + "#" + config.FieldSelector + "#" + string(watcherType)
+}
+
+// Subscription delivers the events of a watcher following an initial snapshot
+type Subscription[T any] struct {
+	// Events is closed when the cache is relisted, the subscriber falls behind or the watcher is stopped
+	Events <-chan T
+
+	stopped     func() bool
+	unsubscribe func()
+}
+
+func newSubscription[T any](watcher *baseWatcher, b *broadcaster[T]) *Subscription[T] {
+	ch := b.subscribe()
+	return &Subscription[T]{
+		Events:      ch,
+		stopped:     watcher.isStopped,
+		unsubscribe: func() { b.unsubscribe(ch) },
+	}
 }
 
-func FormatWatcherID(gvr schema.GroupVersionResource, namespace string, watcherType WatcherType) string {
-	return gvr.String() + "#" + namespace + "#" + string(watcherType)
+// mergeSubscriptions fans in the events of several subscriptions. The merged events channel
+// is closed as soon as any of the subscriptions ends, so the caller resubscribes to all of them.
+func mergeSubscriptions[T any](subs []*Subscription[T]) *Subscription[T] {
+	if len(subs) == 1 {
+		return subs[0]
+	}
+
+	events := make(chan T, DefaultSubscriberBuffer)
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	closeDone := func() {
+		doneOnce.Do(func() { close(done) })
+	}
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer closeDone()
+
+			for {
+				select {
+				case <-done:
+					return
+				case event, ok := <-sub.Events:
+					if !ok {
+						return
+					}
+					select {
+					case events <- event:
+					case <-done:
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return &Subscription[T]{
+		Events: events,
+		stopped: func() bool {
+			for _, sub := range subs {
+				if sub.WatcherStopped() {
+					return true
+				}
+			}
+			return false
+		},
+		unsubscribe: func() {
+			closeDone()
+			closeSubscriptions(subs)
+		},
+	}
+}
+
+// streamUntilClosed turns the acquired watchers of a subscription into streamed ones until the subscription
+// is closed. Streamed watchers can be evicted, which ends the subscription, but only once no idle watcher is left.
+func streamUntilClosed[T any](sub *Subscription[T], watchers []Watcher) *Subscription[T] {
+	for _, watcher := range watchers {
+		watcher.addStream()
+		watcher.release()
+	}
+
+	var closeOnce sync.Once
+	unsubscribe := sub.unsubscribe
+	sub.unsubscribe = func() {
+		unsubscribe()
+		closeOnce.Do(func() {
+			for _, watcher := range watchers {
+				watcher.removeStream()
+			}
+		})
+	}
+	return sub
+}
+
+func closeSubscriptions[T any](subs []*Subscription[T]) {
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// Close releases the subscription
+func (s *Subscription[T]) Close() {
+	s.unsubscribe()
+}
+
+// WatcherStopped reports whether the watcher behind the subscription was stopped, e.g. evicted
+func (s *Subscription[T]) WatcherStopped() bool {
+	return s.stopped()
+}
+
+// broadcaster fans out watch events to subscribers. A subscriber that cannot keep up
+// is dropped by closing its channel, so it can resynchronize from a fresh snapshot.
+type broadcaster[T any] struct {
+	lock sync.Mutex
+	subs map[chan T]struct{}
+}
+
+func newBroadcaster[T any]() *broadcaster[T] {
+	return &broadcaster[T]{
+		subs: make(map[chan T]struct{}),
+	}
+}
+
+func (b *broadcaster[T]) subscribe() chan T {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ch := make(chan T, DefaultSubscriberBuffer)
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster[T]) unsubscribe(ch chan T) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *broadcaster[T]) publish(event T) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *broadcaster[T]) closeAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
 }
@@ -0,0 +1,51 @@
+package kubernetes
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// AppDirName is the name of the directories of the application under the XDG base directories
+	AppDirName = "spyglass"
+
+	// CacheDirEnv overrides the cache directory of the application
+	CacheDirEnv = "SPYGLASS_CACHE_DIR"
+
+	// ConfigDirEnv overrides the configuration directory of the application
+	ConfigDirEnv = "SPYGLASS_CONFIG_DIR"
+)
+
+// cacheDir returns the cache directory of the application, following the XDG Base Directory Specification
+// unless overridden by CacheDirEnv
+func cacheDir() (string, error) {
+	if dir := os.Getenv(CacheDirEnv); dir != "" {
+		return dir, nil
+	}
+	return xdgDir("XDG_CACHE_HOME", ".cache")
+}
+
+// configDir returns the configuration directory of the application, following the XDG Base Directory
+// Specification unless overridden by ConfigDirEnv
+func configDir() (string, error) {
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		return dir, nil
+	}
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// xdgDir returns the application directory under the XDG base directory of the environment variable, or
+// under its default relative to the home directory when unset. Relative paths are invalid per the
+// specification and ignored.
+func xdgDir(env string, defaultDir string) (string, error) {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, AppDirName), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, defaultDir, AppDirName), nil
+}